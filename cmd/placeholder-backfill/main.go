@@ -0,0 +1,127 @@
+// Command placeholder-backfill computes the BlurHash placeholders of
+// thumbnails and profile pictures stored before placeholders existed, so the
+// metadata API never has to decode an image to answer
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/dayquest/cdn/internal/config"
+	"github.com/dayquest/cdn/internal/database"
+	"github.com/dayquest/cdn/internal/imaging"
+	"github.com/dayquest/cdn/internal/storage"
+)
+
+const profilePrefix = "profile-pictures/"
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "print what would be computed without writing")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	db, err := database.NewDatabaseConnection(cfg.DatabaseDSN)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	storageClient, err := storage.NewMinioStorage(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	ctx := context.Background()
+
+	// Thumbnails live at the top of their bucket; anything below a prefix is a
+	// storyboard, preview, candidate or cached variant
+	thumbnails, err := storageClient.ListObjects(ctx, cfg.ThumbnailBucket)
+	if err != nil {
+		log.Fatalf("Failed to list thumbnails: %v", err)
+	}
+	var thumbnailKeys []string
+	for _, object := range thumbnails {
+		if !strings.Contains(object.Key, "/") && strings.HasSuffix(object.Key, ".jpg") {
+			thumbnailKeys = append(thumbnailKeys, object.Key)
+		}
+	}
+	backfill(db, database.PlaceholderThumbnail, thumbnailKeys, *dryRun, func(key string) (io.ReadCloser, error) {
+		return storageClient.GetThumbnail(ctx, key, 0, -1)
+	})
+
+	profiles, err := storageClient.ListObjects(ctx, cfg.ProfileImageBucket)
+	if err != nil {
+		log.Fatalf("Failed to list profile images: %v", err)
+	}
+	var profileKeys []string
+	for _, object := range profiles {
+		name, ok := strings.CutPrefix(object.Key, profilePrefix)
+		if ok && !strings.Contains(name, "/") {
+			profileKeys = append(profileKeys, object.Key)
+		}
+	}
+	backfill(db, database.PlaceholderProfile, profileKeys, *dryRun, func(key string) (io.ReadCloser, error) {
+		return storageClient.GetProfileImage(ctx, key)
+	})
+}
+
+// backfill computes the missing placeholders of kind for keys. Images that
+// cannot be decoded are logged and skipped so one bad file does not stop the run.
+func backfill(db *database.DBHandler, kind string, keys []string, dryRun bool, open func(key string) (io.ReadCloser, error)) {
+	var computed, existing, failed int
+	for _, key := range keys {
+		placeholder, err := db.GetPlaceholder(kind, key)
+		if err != nil {
+			log.Fatalf("Failed to look up placeholder of %s: %v", key, err)
+		}
+		if placeholder != nil {
+			existing++
+			continue
+		}
+
+		if dryRun {
+			log.Printf("Would compute %s placeholder for %s", kind, key)
+			continue
+		}
+
+		if err := compute(db, kind, key, open); err != nil {
+			log.Printf("Skipping %s: %v", key, err)
+			failed++
+			continue
+		}
+		computed++
+	}
+
+	log.Printf("Found %d %s images; computed %d, kept %d existing, skipped %d", len(keys), kind, computed, existing, failed)
+}
+
+func compute(db *database.DBHandler, kind, key string, open func(key string) (io.ReadCloser, error)) error {
+	reader, err := open(key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	img, err := imaging.Decode(reader)
+	if err != nil {
+		return err
+	}
+
+	hash, err := imaging.BlurHash(img)
+	if err != nil {
+		return err
+	}
+
+	return db.SavePlaceholder(kind, key, database.Placeholder{
+		BlurHash: hash,
+		Width:    img.Bounds().Dx(),
+		Height:   img.Bounds().Dy(),
+	})
+}
@@ -0,0 +1,123 @@
+// Command profile-index scans the profile image bucket and indexes images
+// stored under the legacy <name>.<ext> and user_<name>.<ext> keys, so the
+// profile handler can resolve them with a single lookup
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/dayquest/cdn/internal/config"
+	"github.com/dayquest/cdn/internal/database"
+	"github.com/dayquest/cdn/internal/storage"
+)
+
+const profilePrefix = "profile-pictures/"
+
+// legacyName matches the filenames the old lookup tried, in this order of
+// preference: <name>.jpg, .png, .jpeg, then user_<name>.jpg, .png, .jpeg
+var legacyName = regexp.MustCompile(`^(.+)\.(jpg|png|jpeg)$`)
+
+var extensionRank = map[string]int{"jpg": 0, "png": 1, "jpeg": 2}
+
+type candidate struct {
+	key  string
+	rank int
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "print what would be indexed without writing")
+	replace := flag.Bool("replace", false, "overwrite existing index entries")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	db, err := database.NewDatabaseConnection(cfg.DatabaseDSN)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	storageClient, err := storage.NewMinioStorage(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	objects, err := storageClient.ListObjects(context.Background(), cfg.ProfileImageBucket)
+	if err != nil {
+		log.Fatalf("Failed to list profile images: %v", err)
+	}
+
+	best := make(map[string]candidate)
+	for _, object := range objects {
+		name, ok := strings.CutPrefix(object.Key, profilePrefix)
+		if !ok || strings.Contains(name, "/") {
+			continue
+		}
+
+		match := legacyName.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+
+		// user_bob.jpg is what the old lookup found first for user_bob, and
+		// a fallback for bob, so the file is offered under both readings
+		stem, rank := match[1], extensionRank[match[2]]
+		offer(best, stem, object.Key, rank)
+		if username, ok := strings.CutPrefix(stem, "user_"); ok {
+			offer(best, username, object.Key, rank+len(extensionRank))
+		}
+	}
+
+	var added, skipped int
+	for username, c := range best {
+		image := database.ProfileImage{
+			Username:    username,
+			ObjectKey:   c.key,
+			ContentType: "image/jpeg",
+		}
+		if path.Ext(c.key) == ".png" {
+			image.ContentType = "image/png"
+		}
+
+		if *dryRun {
+			log.Printf("Would index %s -> %s", username, c.key)
+			continue
+		}
+
+		if *replace {
+			err = db.SetProfileImage(image)
+		} else {
+			var ok bool
+			ok, err = db.AddProfileImage(image)
+			if err == nil && !ok {
+				skipped++
+				continue
+			}
+		}
+		if err != nil {
+			log.Fatalf("Failed to index %s: %v", username, err)
+		}
+		added++
+	}
+
+	log.Printf("Found %d profile images; indexed %d, kept %d existing entries", len(best), added, skipped)
+}
+
+// offer keeps key as the image of username if it ranks before what the old
+// lookup would otherwise have found
+func offer(best map[string]candidate, username, key string, rank int) {
+	if username == "" || username == "default" {
+		return
+	}
+	if current, ok := best[username]; !ok || rank < current.rank {
+		best[username] = candidate{key: key, rank: rank}
+	}
+}
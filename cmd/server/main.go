@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,6 +14,7 @@ import (
 	"github.com/dayquest/cdn/internal/database"
 	"github.com/dayquest/cdn/internal/handlers"
 	"github.com/dayquest/cdn/internal/storage"
+	"github.com/dayquest/cdn/internal/webhooks"
 	"github.com/gorilla/mux"
 )
 
@@ -29,12 +31,24 @@ func main() {
 	}
 	defer db.Close()
 
+	// Listen for video events published by any instance
+	events, err := database.NewEventListener(cfg.DatabaseDSN)
+	if err != nil {
+		log.Fatalf("Failed to listen for video events: %v", err)
+	}
+	defer events.Close()
+
 	// Initialize storage
 	storageClient, err := storage.NewMinioStorage(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 
+	// Import the bundled badges into an empty catalog
+	if err := storageClient.SeedBadges(context.Background(), db, cfg.BadgeSeedDir); err != nil {
+		log.Printf("Failed to seed badges: %v", err)
+	}
+
 	// Initialize router and handlers
 	router := mux.NewRouter()
 
@@ -45,20 +59,54 @@ func main() {
 
 	// API routes for video metadata
 	api := router.PathPrefix("/api").Subrouter()
-	videoHandler := handlers.NewVideoHandler(storageClient, cfg, db)
+	videoHandler := handlers.NewVideoHandler(storageClient, cfg, db, events)
 	api.HandleFunc("/videos/{video}", videoHandler.GetVideoMetadata).Methods("GET")
+	api.HandleFunc("/videos/{video}/events", videoHandler.StreamVideoEvents).Methods("GET")
 
 	// CDN routes for video streaming
 	cdn := router.PathPrefix("/video").Subrouter()
 	cdn.HandleFunc("/{video}", videoHandler.StreamVideo).Methods("GET")
+	cdn.HandleFunc("/{video}/{asset:.+}", videoHandler.StreamVideoAsset).Methods("GET")
 
 	thumbnailPathSubrouter := router.PathPrefix("/thumbnail").Subrouter()
-	thumbnailHandlerInstance := handlers.NewThumbnailHandler(storageClient)
+	thumbnailHandlerInstance := handlers.NewThumbnailHandler(storageClient, db)
 	thumbnailPathSubrouter.HandleFunc("/{thumbnail}", thumbnailHandlerInstance.GetThumbnail).Methods("GET")
+	thumbnailPathSubrouter.HandleFunc("/{video}/{asset:.+}", thumbnailHandlerInstance.GetThumbnailAsset).Methods("GET")
+
+	// Poster frame selection, guarded by the API token
+	thumbnailAdmin := api.PathPrefix("/videos/{video}/thumbnail").Subrouter()
+	thumbnailAdmin.Use(handlers.RequireAPIToken(cfg.APIToken))
+	thumbnailAdmin.HandleFunc("", thumbnailHandlerInstance.SetThumbnail).Methods("PUT")
+	thumbnailAdmin.HandleFunc("/candidates", thumbnailHandlerInstance.ListThumbnailCandidates).Methods("GET")
 
 	// CDN routes for profile pictures
-	profileHandler := handlers.NewProfileHandler(storageClient)
+	profileHandler := handlers.NewProfileHandler(storageClient, db)
 	router.HandleFunc("/profile-pictures/{username}", profileHandler.GetProfileImage).Methods("GET")
+	router.HandleFunc("/profile-pictures/{username}/placeholder", profileHandler.GetProfilePlaceholder).Methods("GET")
+
+	// Badge manifest and images, with catalog management guarded by the API token
+	badgeHandler := handlers.NewBadgeHandler(storageClient, db)
+	router.HandleFunc("/badges", badgeHandler.ListBadges).Methods("GET")
+	router.HandleFunc("/badges/strip", badgeHandler.GetBadgeStrip).Methods("GET")
+	router.HandleFunc("/badges/{id}", badgeHandler.GetBadge).Methods("GET")
+	badgeAdmin := api.PathPrefix("/badges").Subrouter()
+	badgeAdmin.Use(handlers.RequireAPIToken(cfg.APIToken))
+	badgeAdmin.HandleFunc("", badgeHandler.CreateBadge).Methods("POST")
+	badgeAdmin.HandleFunc("/{id}", badgeHandler.UpdateBadge).Methods("PUT")
+	badgeAdmin.HandleFunc("/{id}", badgeHandler.RetireBadge).Methods("DELETE")
+
+	// Profile picture uploads, guarded by the API token
+	requireToken := handlers.RequireAPIToken(cfg.APIToken)
+	router.Handle("/profile-pictures/{username}", requireToken(http.HandlerFunc(profileHandler.PutProfileImage))).Methods("PUT")
+	router.Handle("/profile-pictures/{username}", requireToken(http.HandlerFunc(profileHandler.DeleteProfileImage))).Methods("DELETE")
+
+	// Webhook delivery log, guarded by the API token
+	dispatcher := webhooks.NewDispatcher(db, cfg)
+	webhookHandler := handlers.NewWebhookHandler(db, dispatcher)
+	admin := api.PathPrefix("/webhooks").Subrouter()
+	admin.Use(handlers.RequireAPIToken(cfg.APIToken))
+	admin.HandleFunc("/deliveries", webhookHandler.ListDeliveries).Methods("GET")
+	admin.HandleFunc("/deliveries/{id:[0-9]+}/replay", webhookHandler.ReplayDelivery).Methods("POST")
 
 	// Configure CORS
 	router.Use(mux.CORSMethodMiddleware(router))
@@ -75,7 +123,11 @@ func main() {
 		})
 	})
 
+	// Cancelled on shutdown so long-lived requests such as event streams end
+	ctx, cancel := context.WithCancel(context.Background())
+
 	srv := &http.Server{
+		BaseContext:       func(net.Listener) context.Context { return ctx },
 		Handler:           router,
 		Addr:             ":" + cfg.ServerPort,
 		WriteTimeout:      30 * time.Second,  // Increased for large video chunks
@@ -91,9 +143,9 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
-	processor := storage.NewVideoProcessor(storageClient, db, 3)
-	ctx, cancel := context.WithCancel(context.Background())
+	processor := storage.NewVideoProcessor(storageClient, db, cfg, dispatcher, 3)
 	go processor.Start(ctx)
+	go dispatcher.Run(ctx)
 
 	log.Printf("Server starting on %s with Minio storage", ":"+cfg.ServerPort)
 	log.Printf("Videos Bucket: %s Raw Videos Bucket: %s", cfg.VideosBucket, cfg.RawVideosBucket)
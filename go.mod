@@ -5,7 +5,9 @@ go 1.24
 require (
 	github.com/go-sql-driver/mysql v1.9.2
 	github.com/gorilla/mux v1.8.1
+	github.com/lib/pq v1.12.3
 	github.com/minio/minio-go/v7 v7.0.90
+	golang.org/x/image v0.25.0
 )
 
 require (
@@ -0,0 +1,142 @@
+// Package avatar renders deterministic default avatars from usernames
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Size is the edge length of rendered identicons in pixels
+const Size = 256
+
+// identiconGrid is the number of cells per row; columns are mirrored so the
+// pattern is symmetric
+const identiconGrid = 5
+
+var background = color.RGBA{R: 0xF0, G: 0xF0, B: 0xF0, A: 0xFF}
+
+// Color derives a saturated, mid-lightness color from the username hash
+func Color(username string) color.RGBA {
+	sum := sha256.Sum256([]byte(username))
+	hue := float64(uint16(sum[0])<<8|uint16(sum[1])) / 65536 * 360
+	return hslToRGB(hue, 0.55, 0.5)
+}
+
+// Identicon renders a symmetric 5x5 pattern in the username's color as PNG
+func Identicon(username string) ([]byte, error) {
+	sum := sha256.Sum256([]byte(username))
+	fg := Color(username)
+
+	img := image.NewRGBA(image.Rect(0, 0, Size, Size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	// Half a cell of padding on every side
+	cell := Size / (identiconGrid + 1)
+	offset := (Size - cell*identiconGrid) / 2
+	half := (identiconGrid + 1) / 2
+
+	for row := 0; row < identiconGrid; row++ {
+		for col := 0; col < half; col++ {
+			// Bytes 0-1 pick the color, so the pattern starts at byte 2
+			if sum[2+row*half+col]&1 == 0 {
+				continue
+			}
+			for _, c := range []int{col, identiconGrid - 1 - col} {
+				rect := image.Rect(offset+c*cell, offset+row*cell, offset+(c+1)*cell, offset+(row+1)*cell)
+				draw.Draw(img, rect, image.NewUniform(fg), image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode identicon: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// InitialsSVG renders up to two initials on the username's color
+func InitialsSVG(username string) []byte {
+	c := Color(username)
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="%[1]d" viewBox="0 0 %[1]d %[1]d">`+
+		`<rect width="100%%" height="100%%" fill="#%02x%02x%02x"/>`+
+		`<text x="50%%" y="50%%" dy=".35em" fill="#ffffff" font-family="Helvetica, Arial, sans-serif" font-size="%d" font-weight="600" text-anchor="middle">%s</text>`+
+		`</svg>`,
+		Size, c.R, c.G, c.B, Size*2/5, html.EscapeString(Initials(username)))
+	return []byte(svg)
+}
+
+// Initials takes the first letter of the first two words of a username,
+// splitting on separators and camel case, or its first two letters
+func Initials(username string) string {
+	username = strings.TrimPrefix(username, "user_")
+
+	var words []string
+	var current []rune
+	for i, r := range username {
+		separator := !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		camel := i > 0 && unicode.IsUpper(r) && len(current) > 0 && unicode.IsLower(current[len(current)-1])
+		if (separator || camel) && len(current) > 0 {
+			words = append(words, string(current))
+			current = nil
+		}
+		if !separator {
+			current = append(current, r)
+		}
+	}
+	if len(current) > 0 {
+		words = append(words, string(current))
+	}
+
+	var initials []rune
+	switch {
+	case len(words) >= 2:
+		initials = []rune{[]rune(words[0])[0], []rune(words[1])[0]}
+	case len(words) == 1:
+		initials = []rune(words[0])
+		if len(initials) > 2 {
+			initials = initials[:2]
+		}
+	default:
+		return "?"
+	}
+	return strings.ToUpper(string(initials))
+}
+
+func hslToRGB(h, s, l float64) color.RGBA {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+
+	return color.RGBA{
+		R: uint8(math.Round((r + m) * 255)),
+		G: uint8(math.Round((g + m) * 255)),
+		B: uint8(math.Round((b + m) * 255)),
+		A: 0xFF,
+	}
+}
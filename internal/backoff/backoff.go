@@ -0,0 +1,24 @@
+// Package backoff computes retry delays shared by the job worker and the
+// webhook dispatcher
+package backoff
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Delay returns the exponential backoff for the given attempt, doubling from
+// base and capped at max, with the upper half randomised so retries of work
+// that failed together spread out
+func Delay(attempt int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -16,6 +19,23 @@ type Config struct {
 	DatabaseDSN        string
 	ThumbnailBucket    string
 	ProfileImageBucket string
+	PackagingMode      string
+	ReconcileInterval  time.Duration
+	WorkerID           string
+	JobLeaseDuration   time.Duration
+	MaxAttempts        int
+	RetryBaseDelay     time.Duration
+	RetryMaxDelay      time.Duration
+	WebhookURLs        []string
+	WebhookSecret      string
+	WebhookMaxAttempts int
+	APIToken           string
+	MaxUploadBytes     int64
+	MaxVideoDuration   time.Duration
+	MaxVideoDimension  int
+	StoryboardInterval time.Duration
+	BadgesBucket       string
+	BadgeSeedDir       string
 }
 
 func Load() (*Config, error) {
@@ -30,6 +50,47 @@ func Load() (*Config, error) {
 		DatabaseDSN:        os.Getenv("DATABASE_DSN"),
 		ThumbnailBucket:    os.Getenv("THUMBNAIL_BUCKET"),
 		ProfileImageBucket: "profile-images",
+		PackagingMode:      getEnvDefault("PACKAGING_MODE", "hls"),
+		WorkerID:           getEnvDefault("WORKER_ID", defaultWorkerID()),
+		WebhookURLs:        getEnvList("WEBHOOK_URLS"),
+		WebhookSecret:      os.Getenv("WEBHOOK_SECRET"),
+		APIToken:           os.Getenv("API_TOKEN"),
+		BadgesBucket:       getEnvDefault("BADGES_BUCKET", "badges"),
+		BadgeSeedDir:       getEnvDefault("BADGE_SEED_DIR", "static/badges"),
+	}
+
+	var err error
+	if cfg.ReconcileInterval, err = getEnvDuration("RECONCILE_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.JobLeaseDuration, err = getEnvDuration("JOB_LEASE_DURATION", 2*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.MaxAttempts, err = getEnvInt("MAX_ATTEMPTS", 5); err != nil {
+		return nil, err
+	}
+	if cfg.RetryBaseDelay, err = getEnvDuration("RETRY_BASE_DELAY", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.RetryMaxDelay, err = getEnvDuration("RETRY_MAX_DELAY", 30*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.WebhookMaxAttempts, err = getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8); err != nil {
+		return nil, err
+	}
+	maxUploadMB, err := getEnvInt("MAX_UPLOAD_MB", 2048)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MaxUploadBytes = int64(maxUploadMB) << 20
+	if cfg.MaxVideoDuration, err = getEnvDuration("MAX_VIDEO_DURATION", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.MaxVideoDimension, err = getEnvInt("MAX_VIDEO_DIMENSION", 7680); err != nil {
+		return nil, err
+	}
+	if cfg.StoryboardInterval, err = getEnvDuration("STORYBOARD_INTERVAL", 5*time.Second); err != nil {
+		return nil, err
 	}
 
 	return cfg, cfg.validate()
@@ -46,5 +107,85 @@ func (c *Config) validate() error {
 		return fmt.Errorf("missing required Minio configuration")
 	}
 
+	switch c.PackagingMode {
+	case "hls", "dash", "cmaf":
+	default:
+		return fmt.Errorf("PACKAGING_MODE must be one of hls, dash or cmaf, got %q", c.PackagingMode)
+	}
+
+	if c.MaxAttempts < 1 {
+		return fmt.Errorf("MAX_ATTEMPTS must be at least 1")
+	}
+
+	if c.RetryMaxDelay < c.RetryBaseDelay {
+		return fmt.Errorf("RETRY_MAX_DELAY must not be less than RETRY_BASE_DELAY")
+	}
+
+	if len(c.WebhookURLs) > 0 && c.WebhookSecret == "" {
+		return fmt.Errorf("WEBHOOK_SECRET is required when WEBHOOK_URLS is set")
+	}
+
+	if c.WebhookMaxAttempts < 1 {
+		return fmt.Errorf("WEBHOOK_MAX_ATTEMPTS must be at least 1")
+	}
+
+	if c.StoryboardInterval < time.Second {
+		return fmt.Errorf("STORYBOARD_INTERVAL must be at least 1s")
+	}
+
 	return nil
 }
+
+func getEnvDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// defaultWorkerID identifies this process as a job lease owner
+func defaultWorkerID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "cdn"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// getEnvList splits a comma-separated variable, dropping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func getEnvInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid integer: %w", key, err)
+	}
+	return n, nil
+}
+
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid duration: %w", key, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive", key)
+	}
+	return d, nil
+}
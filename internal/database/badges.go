@@ -0,0 +1,145 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Badge is a catalog entry. ImageKey points into the badges bucket and ETag
+// identifies the current image.
+type Badge struct {
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Rarity      string     `json:"rarity"`
+	ImageKey    string     `json:"-"`
+	ETag        string     `json:"-"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	RetiredAt   *time.Time `json:"retiredAt,omitempty"`
+}
+
+const badgeColumns = `id, title, description, rarity, image_key, etag, created_at, updated_at, retired_at`
+
+func scanBadge(row interface{ Scan(...interface{}) error }) (Badge, error) {
+	var b Badge
+	var retiredAt sql.NullTime
+	err := row.Scan(&b.ID, &b.Title, &b.Description, &b.Rarity, &b.ImageKey, &b.ETag,
+		&b.CreatedAt, &b.UpdatedAt, &retiredAt)
+	if retiredAt.Valid {
+		b.RetiredAt = &retiredAt.Time
+	}
+	return b, err
+}
+
+// ListBadges returns the catalog in creation order, without retired badges
+// unless includeRetired is set
+func (h *DBHandler) ListBadges(includeRetired bool) ([]Badge, error) {
+	query := `SELECT ` + badgeColumns + ` FROM badges
+              WHERE $1 OR retired_at IS NULL
+              ORDER BY created_at, id`
+	rows, err := h.db.Query(query, includeRetired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list badges: %w", err)
+	}
+	defer rows.Close()
+
+	badges := []Badge{}
+	for rows.Next() {
+		b, err := scanBadge(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan badge: %w", err)
+		}
+		badges = append(badges, b)
+	}
+	return badges, rows.Err()
+}
+
+// GetBadge returns a badge, retired or not, or nil if it does not exist
+func (h *DBHandler) GetBadge(id string) (*Badge, error) {
+	b, err := scanBadge(h.db.QueryRow(`SELECT `+badgeColumns+` FROM badges WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get badge: %w", err)
+	}
+	return &b, nil
+}
+
+// GetBadges returns the badges with the given ids, ordered by id. Unknown
+// ids are left out.
+func (h *DBHandler) GetBadges(ids []string) ([]Badge, error) {
+	query := `SELECT ` + badgeColumns + ` FROM badges WHERE id = ANY($1) ORDER BY id`
+	rows, err := h.db.Query(query, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get badges: %w", err)
+	}
+	defer rows.Close()
+
+	badges := []Badge{}
+	for rows.Next() {
+		b, err := scanBadge(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan badge: %w", err)
+		}
+		badges = append(badges, b)
+	}
+	return badges, rows.Err()
+}
+
+// CreateBadge adds a badge to the catalog. It reports false without error
+// if a badge with the same id already exists.
+func (h *DBHandler) CreateBadge(b Badge) (bool, error) {
+	query := `INSERT INTO badges (id, title, description, rarity, image_key, etag)
+              VALUES ($1, $2, $3, $4, $5, $6)
+              ON CONFLICT (id) DO NOTHING`
+	result, err := h.db.Exec(query, b.ID, b.Title, b.Description, b.Rarity, b.ImageKey, b.ETag)
+	if err != nil {
+		return false, fmt.Errorf("failed to create badge: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to create badge: %w", err)
+	}
+	return n > 0, nil
+}
+
+// UpdateBadge overwrites the editable fields of a badge
+func (h *DBHandler) UpdateBadge(b Badge) error {
+	query := `UPDATE badges
+              SET title = $2, description = $3, rarity = $4, image_key = $5, etag = $6, updated_at = NOW()
+              WHERE id = $1`
+	if _, err := h.db.Exec(query, b.ID, b.Title, b.Description, b.Rarity, b.ImageKey, b.ETag); err != nil {
+		return fmt.Errorf("failed to update badge: %w", err)
+	}
+	return nil
+}
+
+// RetireBadge hides a badge from the catalog listing. Its image stays
+// available for profiles that already show it. It reports whether the badge
+// exists.
+func (h *DBHandler) RetireBadge(id string) (bool, error) {
+	query := `UPDATE badges SET retired_at = COALESCE(retired_at, NOW()), updated_at = NOW() WHERE id = $1`
+	result, err := h.db.Exec(query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to retire badge: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to retire badge: %w", err)
+	}
+	return n > 0, nil
+}
+
+// CountBadges returns the number of badges in the catalog, retired included
+func (h *DBHandler) CountBadges() (int, error) {
+	var n int
+	if err := h.db.QueryRow(`SELECT COUNT(*) FROM badges`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count badges: %w", err)
+	}
+	return n, nil
+}
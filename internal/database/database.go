@@ -5,7 +5,7 @@ import (
     "fmt"
     "log"
     "time"
-    _ "github.com/lib/pq"
+    "github.com/lib/pq"
 )
 
 type VideoStatus int
@@ -18,6 +18,21 @@ const (
     StatusFailed     VideoStatus = 4
 )
 
+func (s VideoStatus) String() string {
+    switch s {
+    case StatusPending:
+        return "pending"
+    case StatusProcessing:
+        return "processing"
+    case StatusCompleted:
+        return "completed"
+    case StatusFailed:
+        return "failed"
+    default:
+        return "unknown"
+    }
+}
+
 type DBHandler struct {
     db *sql.DB
 }
@@ -47,7 +62,13 @@ func NewDatabaseConnection(dsn string) (*DBHandler, error) {
         break
     }
     
-    return &DBHandler{db: db}, nil
+    handler := &DBHandler{db: db}
+    if err := handler.migrate(); err != nil {
+        db.Close()
+        return nil, err
+    }
+
+    return handler, nil
 }
 
 func (h *DBHandler) Close() error {
@@ -73,6 +94,29 @@ func (h *DBHandler) UpdateVideoStatus(videoKey string, status VideoStatus) error
     return nil
 }
 
+// TransitionVideoStatus moves a video to status "to" only if its current status
+// is one of "from". It reports false when the video was in any other state,
+// which means another instance has already moved it on.
+func (h *DBHandler) TransitionVideoStatus(videoKey string, from []VideoStatus, to VideoStatus) (bool, error) {
+    allowed := make([]int64, len(from))
+    for i, status := range from {
+        allowed[i] = int64(status)
+    }
+
+    query := `UPDATE video SET status = $1 WHERE file_path = $2 AND status = ANY($3)`
+    result, err := h.db.Exec(query, to, videoKey, pq.Array(allowed))
+    if err != nil {
+        return false, fmt.Errorf("failed to transition video status: %w", err)
+    }
+
+    rowsAffected, err := result.RowsAffected()
+    if err != nil {
+        return false, fmt.Errorf("failed to get rows affected: %w", err)
+    }
+
+    return rowsAffected > 0, nil
+}
+
 func (h *DBHandler) GetVideoStatus(videoKey string) (VideoStatus, error) {
     var status VideoStatus
     query := `SELECT status FROM video WHERE file_path = $1`
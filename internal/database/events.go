@@ -0,0 +1,153 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// videoEventsChannel is the NOTIFY channel that carries VideoEvent payloads
+// between instances
+const videoEventsChannel = "video_events"
+
+// maxEventReason keeps failure reasons well below the 8000 byte NOTIFY limit
+const maxEventReason = 1000
+
+// subscriberBuffer is how many events a slow subscriber may fall behind before
+// further events for it are dropped
+const subscriberBuffer = 16
+
+// VideoEvent is a status transition or progress update of a video
+type VideoEvent struct {
+	Key        string  `json:"key"`
+	Status     string  `json:"status"`
+	Stage      string  `json:"stage,omitempty"`
+	Percent    float64 `json:"percent,omitempty"`
+	ETASeconds int     `json:"etaSeconds,omitempty"`
+	CDNURL     string  `json:"cdnUrl,omitempty"`
+	Reason     string  `json:"reason,omitempty"`
+}
+
+// Final reports whether no further events will follow for the video
+func (e VideoEvent) Final() bool {
+	return e.Status == StatusCompleted.String() || e.Status == StatusFailed.String()
+}
+
+// PublishVideoEvent broadcasts event to every instance listening on the
+// video events channel
+func (h *DBHandler) PublishVideoEvent(event VideoEvent) error {
+	if len(event.Reason) > maxEventReason {
+		event.Reason = event.Reason[:maxEventReason]
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode video event: %w", err)
+	}
+
+	if _, err := h.db.Exec(`SELECT pg_notify($1, $2)`, videoEventsChannel, string(payload)); err != nil {
+		return fmt.Errorf("failed to publish video event: %w", err)
+	}
+	return nil
+}
+
+// EventListener receives video events through LISTEN and fans them out to
+// subscribers in this process
+type EventListener struct {
+	listener *pq.Listener
+
+	mu          sync.Mutex
+	subscribers map[string]map[chan VideoEvent]struct{}
+}
+
+// NewEventListener opens a dedicated listening connection. It reconnects on
+// its own if the connection drops.
+func NewEventListener(dsn string) (*EventListener, error) {
+	listener := pq.NewListener(dsn, 5*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Video event listener: %v", err)
+		}
+	})
+
+	if err := listener.Listen(videoEventsChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", videoEventsChannel, err)
+	}
+
+	el := &EventListener{
+		listener:    listener,
+		subscribers: make(map[string]map[chan VideoEvent]struct{}),
+	}
+	go el.run()
+
+	return el, nil
+}
+
+func (el *EventListener) run() {
+	for {
+		select {
+		case n, ok := <-el.listener.Notify:
+			if !ok {
+				return
+			}
+			// A nil notification signals a reconnect, after which events may
+			// have been missed; subscribers resync on their own heartbeat
+			if n == nil {
+				continue
+			}
+
+			var event VideoEvent
+			if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+				log.Printf("Failed to decode video event: %v", err)
+				continue
+			}
+			el.dispatch(event)
+		case <-time.After(90 * time.Second):
+			go el.listener.Ping()
+		}
+	}
+}
+
+func (el *EventListener) dispatch(event VideoEvent) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	for ch := range el.subscribers[event.Key] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel that receives the events of videoKey and a
+// function that ends the subscription
+func (el *EventListener) Subscribe(videoKey string) (<-chan VideoEvent, func()) {
+	ch := make(chan VideoEvent, subscriberBuffer)
+
+	el.mu.Lock()
+	if el.subscribers[videoKey] == nil {
+		el.subscribers[videoKey] = make(map[chan VideoEvent]struct{})
+	}
+	el.subscribers[videoKey][ch] = struct{}{}
+	el.mu.Unlock()
+
+	return ch, func() {
+		el.mu.Lock()
+		defer el.mu.Unlock()
+
+		delete(el.subscribers[videoKey], ch)
+		if len(el.subscribers[videoKey]) == 0 {
+			delete(el.subscribers, videoKey)
+		}
+	}
+}
+
+// Close stops listening
+func (el *EventListener) Close() error {
+	return el.listener.Close()
+}
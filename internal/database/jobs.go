@@ -0,0 +1,156 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Job states stored in processing_jobs.state
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// ErrLeaseLost is returned when a job is no longer leased by the caller, usually
+// because the lease expired and another worker reclaimed it
+var ErrLeaseLost = errors.New("job lease lost")
+
+// Job is a unit of video processing work tracked in processing_jobs
+type Job struct {
+	ID             int64
+	ObjectKey      string
+	State          string
+	Attempts       int
+	LeaseOwner     string
+	LeaseExpiresAt time.Time
+	LastError      string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// EnqueueJob records that objectKey needs processing. A job that is already
+// queued or running is left alone. A finished one is only queued again when
+// its video was reset to pending or processing, which is what a new upload
+// does; a leftover raw object of a finished video must not revive it.
+func (h *DBHandler) EnqueueJob(objectKey string) error {
+	query := `INSERT INTO processing_jobs (object_key, state) VALUES ($1, $2)
+              ON CONFLICT (object_key) DO UPDATE
+              SET state = $2, attempts = 0, lease_owner = NULL, lease_expires_at = NULL,
+                  last_error = NULL, run_after = NOW(), updated_at = NOW(), completed_at = NULL
+              WHERE processing_jobs.state IN ($3, $4)
+                AND EXISTS (SELECT 1 FROM video WHERE file_path = $1 AND status = ANY($5))`
+	active := pq.Array([]int64{int64(StatusPending), int64(StatusProcessing)})
+	_, err := h.db.Exec(query, objectKey, JobQueued, JobCompleted, JobFailed, active)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return nil
+}
+
+// ClaimJob leases the oldest queued job that is due, or a running job whose
+// lease has expired, to owner for the given duration. It returns nil when there is no
+// work available.
+func (h *DBHandler) ClaimJob(owner string, lease time.Duration) (*Job, error) {
+	query := `UPDATE processing_jobs
+              SET state = $1, attempts = attempts + 1, lease_owner = $2,
+                  lease_expires_at = NOW() + $3 * INTERVAL '1 millisecond', updated_at = NOW(),
+                  stage = NULL, progress_percent = 0, eta_seconds = NULL, progress_updated_at = NULL
+              WHERE id = (
+                  SELECT id FROM processing_jobs
+                  WHERE (state = $4 AND run_after <= NOW())
+                     OR (state = $1 AND lease_expires_at < NOW())
+                  ORDER BY created_at
+                  LIMIT 1
+                  FOR UPDATE SKIP LOCKED
+              )
+              RETURNING id, object_key, state, attempts, lease_owner, lease_expires_at,
+                        COALESCE(last_error, ''), created_at, updated_at`
+
+	var job Job
+	err := h.db.QueryRow(query, JobRunning, owner, lease.Milliseconds(), JobQueued).Scan(
+		&job.ID, &job.ObjectKey, &job.State, &job.Attempts, &job.LeaseOwner,
+		&job.LeaseExpiresAt, &job.LastError, &job.CreatedAt, &job.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// RenewLease extends the lease on a running job held by owner
+func (h *DBHandler) RenewLease(jobID int64, owner string, lease time.Duration) error {
+	query := `UPDATE processing_jobs
+              SET lease_expires_at = NOW() + $1 * INTERVAL '1 millisecond', updated_at = NOW()
+              WHERE id = $2 AND lease_owner = $3 AND state = $4`
+	return h.execLeased(query, "renew lease", lease.Milliseconds(), jobID, owner, JobRunning)
+}
+
+// CompleteJob marks a job held by owner as done
+func (h *DBHandler) CompleteJob(jobID int64, owner string) error {
+	query := `UPDATE processing_jobs
+              SET state = $1, lease_owner = NULL, lease_expires_at = NULL, last_error = NULL,
+                  updated_at = NOW(), completed_at = NOW()
+              WHERE id = $2 AND lease_owner = $3 AND state = $4`
+	return h.execLeased(query, "complete job", JobCompleted, jobID, owner, JobRunning)
+}
+
+// RetryJob puts a job held by owner back in the queue, to be claimed again no
+// earlier than delay from now, and records the error that caused the retry
+func (h *DBHandler) RetryJob(jobID int64, owner string, reason string, delay time.Duration) error {
+	query := `UPDATE processing_jobs
+              SET state = $1, lease_owner = NULL, lease_expires_at = NULL, last_error = $2,
+                  run_after = NOW() + $3 * INTERVAL '1 millisecond', updated_at = NOW(),
+                  stage = NULL, progress_percent = 0, eta_seconds = NULL
+              WHERE id = $4 AND lease_owner = $5 AND state = $6`
+	return h.execLeased(query, "retry job", JobQueued, reason, delay.Milliseconds(), jobID, owner, JobRunning)
+}
+
+// ReleaseJob hands a job held by owner back to the queue without counting the
+// attempt, for when the work could not even be started
+func (h *DBHandler) ReleaseJob(jobID int64, owner string, delay time.Duration) error {
+	query := `UPDATE processing_jobs
+              SET state = $1, attempts = GREATEST(attempts - 1, 0), lease_owner = NULL,
+                  lease_expires_at = NULL, run_after = NOW() + $2 * INTERVAL '1 millisecond',
+                  updated_at = NOW()
+              WHERE id = $3 AND lease_owner = $4 AND state = $5`
+	return h.execLeased(query, "release job", JobQueued, delay.Milliseconds(), jobID, owner, JobRunning)
+}
+
+// FailJob marks a job held by owner as failed and records the reason
+func (h *DBHandler) FailJob(jobID int64, owner string, reason string) error {
+	query := `UPDATE processing_jobs
+              SET state = $1, lease_owner = NULL, lease_expires_at = NULL, last_error = $2,
+                  updated_at = NOW(), completed_at = NOW()
+              WHERE id = $3 AND lease_owner = $4 AND state = $5`
+	return h.execLeased(query, "fail job", JobFailed, reason, jobID, owner, JobRunning)
+}
+
+// execLeased runs an update that is only valid while the caller holds the
+// lease and maps "no rows" to ErrLeaseLost
+func (h *DBHandler) execLeased(query, action string, args ...interface{}) error {
+	result, err := h.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to %s: %w", action, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrLeaseLost
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// Advisory lock classes, used as the first key of pg_try_advisory_lock so CDN
+// locks cannot collide with locks taken by other users of the database
+const (
+	lockClassVideo  int32 = 7301
+	lockClassLeader int32 = 7302
+)
+
+// AdvisoryLock is a session-level Postgres advisory lock held on a dedicated
+// connection. Postgres drops it when that connection dies, so a crashed
+// instance never leaves a lock behind.
+type AdvisoryLock struct {
+	conn  *sql.Conn
+	class int32
+	key   string
+}
+
+// TryLockVideo takes the per-video processing lock. It returns nil without an
+// error when another instance already holds it.
+func (h *DBHandler) TryLockVideo(ctx context.Context, videoKey string) (*AdvisoryLock, error) {
+	return h.tryAdvisoryLock(ctx, lockClassVideo, videoKey)
+}
+
+// TryAcquireLeadership takes the named leader lock. It returns nil without an
+// error when another instance is the leader.
+func (h *DBHandler) TryAcquireLeadership(ctx context.Context, name string) (*AdvisoryLock, error) {
+	return h.tryAdvisoryLock(ctx, lockClassLeader, name)
+}
+
+func (h *DBHandler) tryAdvisoryLock(ctx context.Context, class int32, key string) (*AdvisoryLock, error) {
+	conn, err := h.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection for advisory lock: %w", err)
+	}
+
+	var acquired bool
+	query := `SELECT pg_try_advisory_lock($1, hashtext($2))`
+	if err := conn.QueryRowContext(ctx, query, class, key).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to try advisory lock: %w", err)
+	}
+
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+
+	return &AdvisoryLock{conn: conn, class: class, key: key}, nil
+}
+
+// Alive reports whether the session holding the lock is still connected
+func (l *AdvisoryLock) Alive(ctx context.Context) bool {
+	return l.conn.PingContext(ctx) == nil
+}
+
+// Release unlocks and gives the connection back. If the unlock fails the
+// connection is discarded instead, which ends the session and frees the lock.
+func (l *AdvisoryLock) Release() error {
+	query := `SELECT pg_advisory_unlock($1, hashtext($2))`
+	if _, err := l.conn.ExecContext(context.Background(), query, l.class, l.key); err != nil {
+		l.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		l.conn.Close()
+		return fmt.Errorf("failed to release advisory lock: %w", err)
+	}
+	return l.conn.Close()
+}
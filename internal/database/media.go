@@ -0,0 +1,52 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// MediaInfo holds the properties ffprobe reports for an upload. Width and
+// Height are display dimensions, with Rotation (clockwise degrees) applied.
+type MediaInfo struct {
+	Duration   float64 `json:"duration"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	FrameRate  float64 `json:"frameRate"`
+	VideoCodec string  `json:"videoCodec"`
+	AudioCodec string  `json:"audioCodec,omitempty"`
+	Rotation   int     `json:"rotation"`
+	Bitrate    int64   `json:"bitrate"`
+}
+
+// SaveMediaInfo stores the probe result for a video, replacing an earlier one
+func (h *DBHandler) SaveMediaInfo(videoKey string, info MediaInfo) error {
+	query := `INSERT INTO video_media_info
+                  (file_path, duration_seconds, width, height, frame_rate, video_codec, audio_codec, rotation, bitrate, probed_at)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+              ON CONFLICT (file_path) DO UPDATE SET
+                  duration_seconds = $2, width = $3, height = $4, frame_rate = $5,
+                  video_codec = $6, audio_codec = $7, rotation = $8, bitrate = $9, probed_at = NOW()`
+	_, err := h.db.Exec(query, videoKey, info.Duration, info.Width, info.Height, info.FrameRate,
+		info.VideoCodec, info.AudioCodec, info.Rotation, info.Bitrate)
+	if err != nil {
+		return fmt.Errorf("failed to save media info: %w", err)
+	}
+	return nil
+}
+
+// GetMediaInfo returns the stored probe result for a video, or nil if the
+// video has not been probed yet
+func (h *DBHandler) GetMediaInfo(videoKey string) (*MediaInfo, error) {
+	var info MediaInfo
+	query := `SELECT duration_seconds, width, height, frame_rate, video_codec, audio_codec, rotation, bitrate
+              FROM video_media_info WHERE file_path = $1`
+	err := h.db.QueryRow(query, videoKey).Scan(&info.Duration, &info.Width, &info.Height, &info.FrameRate,
+		&info.VideoCodec, &info.AudioCodec, &info.Rotation, &info.Bitrate)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get media info: %w", err)
+	}
+	return &info, nil
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Kinds of image a placeholder can belong to
+const (
+	PlaceholderThumbnail = "thumbnail"
+	PlaceholderProfile   = "profile"
+)
+
+// Placeholder is a BlurHash of an image along with the image's dimensions,
+// which clients need to decode it at the right aspect ratio
+type Placeholder struct {
+	BlurHash string `json:"blurhash"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+// SavePlaceholder stores the placeholder of an image, replacing an earlier one
+func (h *DBHandler) SavePlaceholder(kind, objectKey string, placeholder Placeholder) error {
+	query := `INSERT INTO image_placeholders (kind, object_key, blurhash, width, height, updated_at)
+              VALUES ($1, $2, $3, $4, $5, NOW())
+              ON CONFLICT (kind, object_key) DO UPDATE SET
+                  blurhash = $3, width = $4, height = $5, updated_at = NOW()`
+	_, err := h.db.Exec(query, kind, objectKey, placeholder.BlurHash, placeholder.Width, placeholder.Height)
+	if err != nil {
+		return fmt.Errorf("failed to save placeholder: %w", err)
+	}
+	return nil
+}
+
+// GetPlaceholder returns the placeholder of an image, or nil if none has been
+// computed yet
+func (h *DBHandler) GetPlaceholder(kind, objectKey string) (*Placeholder, error) {
+	var placeholder Placeholder
+	query := `SELECT blurhash, width, height FROM image_placeholders WHERE kind = $1 AND object_key = $2`
+	err := h.db.QueryRow(query, kind, objectKey).Scan(&placeholder.BlurHash, &placeholder.Width, &placeholder.Height)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get placeholder: %w", err)
+	}
+	return &placeholder, nil
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// SavePreviews records the current preview clip of a video, keyed by format,
+// and drops formats the new clip does not have
+func (h *DBHandler) SavePreviews(videoKey string, keys map[string]string) error {
+	formats := make([]string, 0, len(keys))
+	objectKeys := make([]string, 0, len(keys))
+	for format, key := range keys {
+		formats = append(formats, format)
+		objectKeys = append(objectKeys, key)
+	}
+
+	query := `WITH removed AS (
+                  DELETE FROM video_previews WHERE file_path = $1 AND NOT (format = ANY($2))
+              )
+              INSERT INTO video_previews (file_path, format, object_key, updated_at)
+              SELECT $1, format, object_key, NOW() FROM unnest($2::text[], $3::text[]) AS p(format, object_key)
+              ON CONFLICT (file_path, format) DO UPDATE SET
+                  object_key = EXCLUDED.object_key, updated_at = NOW()`
+	if _, err := h.db.Exec(query, videoKey, pq.Array(formats), pq.Array(objectKeys)); err != nil {
+		return fmt.Errorf("failed to save previews: %w", err)
+	}
+	return nil
+}
+
+// GetPreviews returns the object keys of a video's preview clip by format,
+// which is empty if none has been generated
+func (h *DBHandler) GetPreviews(videoKey string) (map[string]string, error) {
+	rows, err := h.db.Query(`SELECT format, object_key FROM video_previews WHERE file_path = $1`, videoKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get previews: %w", err)
+	}
+	defer rows.Close()
+
+	previews := map[string]string{}
+	for rows.Next() {
+		var format, key string
+		if err := rows.Scan(&format, &key); err != nil {
+			return nil, fmt.Errorf("failed to get previews: %w", err)
+		}
+		previews[format] = key
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get previews: %w", err)
+	}
+	return previews, nil
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ProfileImage points a username at its image in the profile bucket
+type ProfileImage struct {
+	Username    string
+	ObjectKey   string
+	ContentType string
+}
+
+// GetProfileImage returns the indexed profile image of a user, or nil if the
+// user has none
+func (h *DBHandler) GetProfileImage(username string) (*ProfileImage, error) {
+	image := ProfileImage{Username: username}
+	query := `SELECT object_key, content_type FROM profile_images WHERE username = $1`
+	err := h.db.QueryRow(query, username).Scan(&image.ObjectKey, &image.ContentType)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile image: %w", err)
+	}
+	return &image, nil
+}
+
+// SetProfileImage indexes a user's profile image, replacing any earlier entry
+func (h *DBHandler) SetProfileImage(image ProfileImage) error {
+	query := `INSERT INTO profile_images (username, object_key, content_type, updated_at)
+              VALUES ($1, $2, $3, NOW())
+              ON CONFLICT (username) DO UPDATE SET
+                  object_key = $2, content_type = $3, updated_at = NOW()`
+	if _, err := h.db.Exec(query, image.Username, image.ObjectKey, image.ContentType); err != nil {
+		return fmt.Errorf("failed to set profile image: %w", err)
+	}
+	return nil
+}
+
+// AddProfileImage indexes a profile image only if the user has no entry yet,
+// so imports never override uploads. It reports whether a row was added.
+func (h *DBHandler) AddProfileImage(image ProfileImage) (bool, error) {
+	query := `INSERT INTO profile_images (username, object_key, content_type)
+              VALUES ($1, $2, $3)
+              ON CONFLICT (username) DO NOTHING`
+	result, err := h.db.Exec(query, image.Username, image.ObjectKey, image.ContentType)
+	if err != nil {
+		return false, fmt.Errorf("failed to add profile image: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to add profile image: %w", err)
+	}
+	return n > 0, nil
+}
+
+// DeleteProfileImage removes a user's index entry
+func (h *DBHandler) DeleteProfileImage(username string) error {
+	if _, err := h.db.Exec(`DELETE FROM profile_images WHERE username = $1`, username); err != nil {
+		return fmt.Errorf("failed to delete profile image: %w", err)
+	}
+	return nil
+}
+
+// ProfileImageKeyInUse reports whether any user other than username is
+// indexed to objectKey. Legacy imports can point two users at one file.
+func (h *DBHandler) ProfileImageKeyInUse(objectKey, username string) (bool, error) {
+	var inUse bool
+	query := `SELECT EXISTS (SELECT 1 FROM profile_images WHERE object_key = $1 AND username <> $2)`
+	if err := h.db.QueryRow(query, objectKey, username).Scan(&inUse); err != nil {
+		return false, fmt.Errorf("failed to check profile image key: %w", err)
+	}
+	return inUse, nil
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// JobProgress is the latest progress reported for a video's processing job
+type JobProgress struct {
+	State     string
+	Stage     string
+	Percent   float64
+	ETA       time.Duration
+	Attempts  int
+	LastError string
+	UpdatedAt time.Time
+}
+
+// UpdateJobProgress records the current stage, overall percentage and
+// estimated time remaining of the running job for objectKey. A zero ETA is
+// stored as unknown.
+func (h *DBHandler) UpdateJobProgress(objectKey string, progress JobProgress) error {
+	var eta sql.NullInt64
+	if progress.ETA > 0 {
+		eta = sql.NullInt64{Int64: int64(progress.ETA.Round(time.Second) / time.Second), Valid: true}
+	}
+
+	query := `UPDATE processing_jobs
+              SET stage = $1, progress_percent = $2, eta_seconds = $3, progress_updated_at = NOW()
+              WHERE object_key = $4 AND state = $5`
+	_, err := h.db.Exec(query, progress.Stage, progress.Percent, eta, objectKey, JobRunning)
+	if err != nil {
+		return fmt.Errorf("failed to update job progress: %w", err)
+	}
+	return nil
+}
+
+// GetJobProgress returns the progress of the job for objectKey, or nil if the
+// video has no job
+func (h *DBHandler) GetJobProgress(objectKey string) (*JobProgress, error) {
+	var progress JobProgress
+	var stage sql.NullString
+	var eta sql.NullInt64
+
+	query := `SELECT state, stage, progress_percent, eta_seconds, attempts, COALESCE(last_error, ''),
+                     COALESCE(progress_updated_at, updated_at)
+              FROM processing_jobs WHERE object_key = $1`
+	err := h.db.QueryRow(query, objectKey).Scan(
+		&progress.State, &stage, &progress.Percent, &eta, &progress.Attempts, &progress.LastError, &progress.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job progress: %w", err)
+	}
+
+	progress.Stage = stage.String
+	if eta.Valid {
+		progress.ETA = time.Duration(eta.Int64) * time.Second
+	}
+
+	return &progress, nil
+}
@@ -0,0 +1,96 @@
+package database
+
+import "fmt"
+
+// schema holds the statements for the tables owned by the CDN. The video table
+// itself belongs to the main backend and is not created here. Every statement
+// must be idempotent because it runs on each startup.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS processing_jobs (
+		id               BIGSERIAL PRIMARY KEY,
+		object_key       TEXT NOT NULL UNIQUE,
+		state            TEXT NOT NULL DEFAULT 'queued',
+		attempts         INTEGER NOT NULL DEFAULT 0,
+		lease_owner      TEXT,
+		lease_expires_at TIMESTAMPTZ,
+		last_error       TEXT,
+		created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		completed_at     TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS processing_jobs_claim_idx ON processing_jobs (state, lease_expires_at)`,
+	`ALTER TABLE processing_jobs ADD COLUMN IF NOT EXISTS run_after TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+	`ALTER TABLE processing_jobs ADD COLUMN IF NOT EXISTS stage TEXT`,
+	`ALTER TABLE processing_jobs ADD COLUMN IF NOT EXISTS progress_percent REAL NOT NULL DEFAULT 0`,
+	`ALTER TABLE processing_jobs ADD COLUMN IF NOT EXISTS eta_seconds INTEGER`,
+	`ALTER TABLE processing_jobs ADD COLUMN IF NOT EXISTS progress_updated_at TIMESTAMPTZ`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id              BIGSERIAL PRIMARY KEY,
+		event           TEXT NOT NULL,
+		url             TEXT NOT NULL,
+		payload         JSONB NOT NULL,
+		state           TEXT NOT NULL DEFAULT 'pending',
+		attempts        INTEGER NOT NULL DEFAULT 0,
+		response_status INTEGER,
+		last_error      TEXT,
+		next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		delivered_at    TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (state, next_attempt_at)`,
+	`CREATE TABLE IF NOT EXISTS video_media_info (
+		file_path        TEXT PRIMARY KEY,
+		duration_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+		width            INTEGER NOT NULL DEFAULT 0,
+		height           INTEGER NOT NULL DEFAULT 0,
+		frame_rate       DOUBLE PRECISION NOT NULL DEFAULT 0,
+		video_codec      TEXT NOT NULL DEFAULT '',
+		audio_codec      TEXT NOT NULL DEFAULT '',
+		rotation         INTEGER NOT NULL DEFAULT 0,
+		bitrate          BIGINT NOT NULL DEFAULT 0,
+		probed_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS video_previews (
+		file_path  TEXT NOT NULL,
+		format     TEXT NOT NULL,
+		object_key TEXT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (file_path, format)
+	)`,
+	`CREATE TABLE IF NOT EXISTS profile_images (
+		username     TEXT PRIMARY KEY,
+		object_key   TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS image_placeholders (
+		kind       TEXT NOT NULL,
+		object_key TEXT NOT NULL,
+		blurhash   TEXT NOT NULL,
+		width      INTEGER NOT NULL,
+		height     INTEGER NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (kind, object_key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS badges (
+		id          TEXT PRIMARY KEY,
+		title       TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		rarity      TEXT NOT NULL,
+		image_key   TEXT NOT NULL,
+		etag        TEXT NOT NULL,
+		created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		retired_at  TIMESTAMPTZ
+	)`,
+}
+
+func (h *DBHandler) migrate() error {
+	for _, stmt := range schema {
+		if _, err := h.db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to apply schema: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Webhook delivery states stored in webhook_deliveries.state
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// webhookSendGuard keeps a claimed delivery from being claimed again while
+// it is being sent
+const webhookSendGuard = time.Minute
+
+// WebhookDelivery is one attempt series of sending an event to one endpoint
+type WebhookDelivery struct {
+	ID             int64     `json:"id"`
+	Event          string    `json:"event"`
+	URL            string    `json:"url"`
+	Payload        []byte    `json:"-"`
+	State          string    `json:"state"`
+	Attempts       int       `json:"attempts"`
+	ResponseStatus int       `json:"responseStatus,omitempty"`
+	LastError      string    `json:"lastError,omitempty"`
+	NextAttemptAt  time.Time `json:"nextAttemptAt"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// InsertWebhookDelivery queues payload for delivery to url
+func (h *DBHandler) InsertWebhookDelivery(event, url string, payload []byte) error {
+	query := `INSERT INTO webhook_deliveries (event, url, payload) VALUES ($1, $2, $3)`
+	if _, err := h.db.Exec(query, event, url, string(payload)); err != nil {
+		return fmt.Errorf("failed to insert webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries picks up to limit pending deliveries that are due and
+// counts the attempt. Claimed rows are pushed back by webhookSendGuard so that
+// other instances skip them while they are in flight.
+func (h *DBHandler) ClaimWebhookDeliveries(limit int) ([]WebhookDelivery, error) {
+	query := `UPDATE webhook_deliveries
+              SET attempts = attempts + 1,
+                  next_attempt_at = NOW() + $1 * INTERVAL '1 millisecond', updated_at = NOW()
+              WHERE id IN (
+                  SELECT id FROM webhook_deliveries
+                  WHERE state = $2 AND next_attempt_at <= NOW()
+                  ORDER BY next_attempt_at
+                  LIMIT $3
+                  FOR UPDATE SKIP LOCKED
+              )
+              RETURNING id, event, url, payload, state, attempts, created_at`
+
+	rows, err := h.db.Query(query, webhookSendGuard.Milliseconds(), DeliveryPending, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.Event, &d.URL, &d.Payload, &d.State, &d.Attempts, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+// MarkWebhookDelivered records a successful delivery
+func (h *DBHandler) MarkWebhookDelivered(id int64, responseStatus int) error {
+	query := `UPDATE webhook_deliveries
+              SET state = $1, response_status = $2, last_error = NULL,
+                  updated_at = NOW(), delivered_at = NOW()
+              WHERE id = $3`
+	if _, err := h.db.Exec(query, DeliveryDelivered, responseStatus, id); err != nil {
+		return fmt.Errorf("failed to mark webhook delivered: %w", err)
+	}
+	return nil
+}
+
+// RetryWebhookDelivery records a failed attempt and schedules the next one
+func (h *DBHandler) RetryWebhookDelivery(id int64, responseStatus int, reason string, delay time.Duration) error {
+	query := `UPDATE webhook_deliveries
+              SET response_status = NULLIF($1, 0), last_error = $2,
+                  next_attempt_at = NOW() + $3 * INTERVAL '1 millisecond', updated_at = NOW()
+              WHERE id = $4`
+	if _, err := h.db.Exec(query, responseStatus, reason, delay.Milliseconds(), id); err != nil {
+		return fmt.Errorf("failed to reschedule webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// FailWebhookDelivery gives up on a delivery; it stays in the log for replay
+func (h *DBHandler) FailWebhookDelivery(id int64, responseStatus int, reason string) error {
+	query := `UPDATE webhook_deliveries
+              SET state = $1, response_status = NULLIF($2, 0), last_error = $3, updated_at = NOW()
+              WHERE id = $4`
+	if _, err := h.db.Exec(query, DeliveryFailed, responseStatus, reason, id); err != nil {
+		return fmt.Errorf("failed to fail webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// ReplayWebhookDelivery queues a delivery again from scratch. It reports false
+// when there is no delivery with that id.
+func (h *DBHandler) ReplayWebhookDelivery(id int64) (bool, error) {
+	query := `UPDATE webhook_deliveries
+              SET state = $1, attempts = 0, next_attempt_at = NOW(), updated_at = NOW()
+              WHERE id = $2`
+	result, err := h.db.Exec(query, DeliveryPending, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to replay webhook delivery: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// ListWebhookDeliveries returns the most recent deliveries, optionally only
+// those in the given state
+func (h *DBHandler) ListWebhookDeliveries(state string, limit int) ([]WebhookDelivery, error) {
+	query := `SELECT id, event, url, state, attempts, COALESCE(response_status, 0),
+                     COALESCE(last_error, ''), next_attempt_at, created_at
+              FROM webhook_deliveries
+              WHERE $1 = '' OR state = $1
+              ORDER BY created_at DESC
+              LIMIT $2`
+
+	rows, err := h.db.Query(query, state, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		err := rows.Scan(&d.ID, &d.Event, &d.URL, &d.State, &d.Attempts, &d.ResponseStatus,
+			&d.LastError, &d.NextAttemptAt, &d.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return deliveries, nil
+}
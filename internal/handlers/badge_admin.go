@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/dayquest/cdn/internal/database"
+	"github.com/dayquest/cdn/internal/imaging"
+	"github.com/dayquest/cdn/internal/storage"
+	"github.com/gorilla/mux"
+)
+
+// Limits for uploaded badge images. Badges are square.
+const (
+	maxBadgeUploadBytes = 2 << 20
+	minBadgeDimension   = 64
+	maxBadgeDimension   = 1024
+)
+
+var validBadgeID = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+var badgeRarities = map[string]bool{
+	"common":    true,
+	"uncommon":  true,
+	"rare":      true,
+	"epic":      true,
+	"legendary": true,
+}
+
+// CreateBadge adds a badge from a multipart form with id, title,
+// description, rarity and image fields
+func (h *BadgeHandler) CreateBadge(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !parseBadgeForm(w, r) {
+		return
+	}
+
+	badge := database.Badge{
+		ID:          r.FormValue("id"),
+		Title:       r.FormValue("title"),
+		Description: r.FormValue("description"),
+		Rarity:      r.FormValue("rarity"),
+	}
+	if msg := validateBadge(badge); msg != "" {
+		writeJSONError(w, http.StatusBadRequest, "error", msg)
+		return
+	}
+
+	existing, err := h.db.GetBadge(badge.ID)
+	if err != nil {
+		log.Printf("Error getting badge %s: %v", badge.ID, err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Error creating badge")
+		return
+	}
+	if existing != nil {
+		writeJSONError(w, http.StatusConflict, "conflict", "A badge with this id already exists")
+		return
+	}
+
+	image, ok := readBadgeImage(w, r, true)
+	if !ok {
+		return
+	}
+	if err := h.storeBadgeImage(ctx, &badge, image); err != nil {
+		log.Printf("Error storing image of badge %s: %v", badge.ID, err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Error storing badge image")
+		return
+	}
+
+	created, err := h.db.CreateBadge(badge)
+	if err != nil {
+		log.Printf("Error creating badge %s: %v", badge.ID, err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Error creating badge")
+		return
+	}
+	if !created {
+		writeJSONError(w, http.StatusConflict, "conflict", "A badge with this id already exists")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"status": "ok",
+		"data":   badgeResponse{Badge: badge, Image: "/badges/" + badge.ID},
+	})
+}
+
+// UpdateBadge changes the fields present in a multipart form; an image field
+// replaces the badge image
+func (h *BadgeHandler) UpdateBadge(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !parseBadgeForm(w, r) {
+		return
+	}
+
+	badge, err := h.db.GetBadge(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("Error getting badge: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Error updating badge")
+		return
+	}
+	if badge == nil {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Badge not found")
+		return
+	}
+
+	for field, value := range map[string]*string{
+		"title":       &badge.Title,
+		"description": &badge.Description,
+		"rarity":      &badge.Rarity,
+	} {
+		if _, ok := r.MultipartForm.Value[field]; ok {
+			*value = r.FormValue(field)
+		}
+	}
+	if msg := validateBadge(*badge); msg != "" {
+		writeJSONError(w, http.StatusBadRequest, "error", msg)
+		return
+	}
+
+	image, ok := readBadgeImage(w, r, false)
+	if !ok {
+		return
+	}
+	if image.data != nil {
+		previousKey := badge.ImageKey
+		if err := h.storeBadgeImage(ctx, badge, image); err != nil {
+			log.Printf("Error storing image of badge %s: %v", badge.ID, err)
+			writeJSONError(w, http.StatusInternalServerError, "error", "Error storing badge image")
+			return
+		}
+		if previousKey != badge.ImageKey {
+			if err := h.storage.DeleteBadgeImage(ctx, previousKey); err != nil {
+				log.Printf("Error deleting old image of badge %s: %v", badge.ID, err)
+			}
+			if err := h.storage.PurgeBadgeVariants(ctx, previousKey); err != nil {
+				log.Printf("Error purging variants of badge %s: %v", badge.ID, err)
+			}
+		}
+	}
+
+	if err := h.db.UpdateBadge(*badge); err != nil {
+		log.Printf("Error updating badge %s: %v", badge.ID, err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Error updating badge")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"data":   badgeResponse{Badge: *badge, Image: "/badges/" + badge.ID},
+	})
+}
+
+// RetireBadge removes a badge from the manifest. Its image keeps being
+// served for profiles that already show it.
+func (h *BadgeHandler) RetireBadge(w http.ResponseWriter, r *http.Request) {
+	found, err := h.db.RetireBadge(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("Error retiring badge: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Error retiring badge")
+		return
+	}
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Badge not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status":  "ok",
+		"message": "Badge retired",
+	})
+}
+
+// storeBadgeImage uploads a validated image under the badge's id and points
+// the badge at it
+func (h *BadgeHandler) storeBadgeImage(ctx context.Context, badge *database.Badge, img badgeImage) error {
+	imageKey := badge.ID + imaging.Extension(img.format)
+	if err := h.storage.UploadBadgeImage(ctx, imageKey, bytes.NewReader(img.data), imaging.ContentType(img.format)); err != nil {
+		return err
+	}
+	if err := h.storage.PurgeBadgeVariants(ctx, imageKey); err != nil {
+		return err
+	}
+
+	badge.ImageKey = imageKey
+	badge.ETag = storage.BadgeETag(img.data)
+	return nil
+}
+
+func parseBadgeForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBadgeUploadBytes+1<<20)
+	if err := r.ParseMultipartForm(maxBadgeUploadBytes); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "error", "Badge upload exceeds the size limit")
+			return false
+		}
+		writeJSONError(w, http.StatusBadRequest, "error", "Expected a multipart form")
+		return false
+	}
+	return true
+}
+
+func validateBadge(badge database.Badge) string {
+	switch {
+	case !validBadgeID.MatchString(badge.ID):
+		return "id must be 1-32 lowercase letters, digits or underscores"
+	case badge.ID == "strip":
+		return "strip is a reserved id"
+	case badge.Title == "":
+		return "title is required"
+	case !badgeRarities[badge.Rarity]:
+		return "rarity must be one of common, uncommon, rare, epic or legendary"
+	}
+	return ""
+}
+
+// badgeImage is an uploaded badge image ready to store
+type badgeImage struct {
+	data   []byte
+	format string
+}
+
+// readBadgeImage reads and validates the image field. When the field is
+// optional and absent it returns nil data.
+func readBadgeImage(w http.ResponseWriter, r *http.Request, required bool) (badgeImage, bool) {
+	file, _, err := r.FormFile("image")
+	if errors.Is(err, http.ErrMissingFile) && !required {
+		return badgeImage{}, true
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "error", "image is required")
+		return badgeImage{}, false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "error", "Failed to read image")
+		return badgeImage{}, false
+	}
+
+	img, err := validateBadgeImage(data)
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, "invalid_image", err.Error())
+		return badgeImage{}, false
+	}
+	return img, true
+}
+
+// validateBadgeImage checks an upload is a square JPEG, PNG or WebP of a
+// sensible size. WebP is converted to PNG, which every client can show.
+func validateBadgeImage(data []byte) (badgeImage, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return badgeImage{}, fmt.Errorf("image is not a JPEG, PNG or WebP")
+	}
+	if cfg.Width != cfg.Height {
+		return badgeImage{}, fmt.Errorf("image must be square, got %dx%d", cfg.Width, cfg.Height)
+	}
+	if cfg.Width < minBadgeDimension || cfg.Width > maxBadgeDimension {
+		return badgeImage{}, fmt.Errorf("image must be between %d and %d pixels wide", minBadgeDimension, maxBadgeDimension)
+	}
+
+	switch format {
+	case imaging.FormatJPEG, imaging.FormatPNG:
+		return badgeImage{data: data, format: format}, nil
+	case imaging.FormatWebP:
+		img, err := imaging.Decode(bytes.NewReader(data))
+		if err != nil {
+			return badgeImage{}, err
+		}
+		var buf bytes.Buffer
+		if err := imaging.Encode(context.Background(), &buf, img, imaging.FormatPNG, 0); err != nil {
+			return badgeImage{}, err
+		}
+		return badgeImage{data: buf.Bytes(), format: imaging.FormatPNG}, nil
+	default:
+		return badgeImage{}, fmt.Errorf("image is not a JPEG, PNG or WebP")
+	}
+}
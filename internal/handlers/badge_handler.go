@@ -1,47 +1,142 @@
 package handlers
 
 import (
-	"fmt"
+	"io"
+	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/dayquest/cdn/internal/database"
+	"github.com/dayquest/cdn/internal/imaging"
+	"github.com/dayquest/cdn/internal/storage"
 	"github.com/gorilla/mux"
 )
 
-// BadgeHandler handles badge image requests
-func BadgeHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	badgeID := vars["id"]
-
-	// Try different image extensions
-	extensions := []string{".jpg", ".png", ".jpeg"}
-	var imagePath string
-	var found bool
-
-	for _, ext := range extensions {
-		testPath := filepath.Join("static", "badges", fmt.Sprintf("%s%s", badgeID, ext))
-		if _, err := os.Stat(testPath); err == nil {
-			imagePath = testPath
-			found = true
-			break
-		}
+// badgeCacheControl lets clients keep badges for a day and revalidate with
+// the ETag afterwards
+const badgeCacheControl = "public, max-age=86400"
+
+// legacyBadgeSuffixes are stripped from ids in the old filename-based URLs
+var legacyBadgeSuffixes = []string{"_badge_round", "_bagde_round"}
+
+// badgeResponse is a catalog entry as listed in the manifest
+type badgeResponse struct {
+	database.Badge
+	Image string `json:"image"`
+}
+
+// BadgeHandler serves the badge catalog and badge images
+type BadgeHandler struct {
+	storage storage.Storage
+	db      *database.DBHandler
+}
+
+// NewBadgeHandler creates a new BadgeHandler
+func NewBadgeHandler(storage storage.Storage, db *database.DBHandler) *BadgeHandler {
+	return &BadgeHandler{
+		storage: storage,
+		db:      db,
+	}
+}
+
+func badgeStore(s storage.Storage) imageStore {
+	return imageStore{
+		get:    s.GetBadgeImage,
+		stat:   s.StatBadgeImage,
+		upload: s.UploadBadgeImage,
+	}
+}
+
+// ListBadges returns the badge manifest with image URLs. Retired badges are
+// included with ?include=retired.
+func (h *BadgeHandler) ListBadges(w http.ResponseWriter, r *http.Request) {
+	badges, err := h.db.ListBadges(r.URL.Query().Get("include") == "retired")
+	if err != nil {
+		log.Printf("Error listing badges: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Error listing badges")
+		return
 	}
 
-	if !found {
+	manifest := make([]badgeResponse, len(badges))
+	for i, badge := range badges {
+		manifest[i] = badgeResponse{Badge: badge, Image: "/badges/" + badge.ID}
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"data":   manifest,
+	})
+}
+
+// GetBadge serves a badge image, resized and re-encoded on request
+func (h *BadgeHandler) GetBadge(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	badge, err := h.lookupBadge(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("Error getting badge: %v", err)
+		http.Error(w, "Failed to retrieve badge", http.StatusInternalServerError)
+		return
+	}
+	if badge == nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	// Set appropriate content type
-	ext := filepath.Ext(imagePath)
-	switch ext {
-	case ".jpg", ".jpeg":
-		w.Header().Set("Content-Type", "image/jpeg")
-	case ".png":
-		w.Header().Set("Content-Type", "image/png")
+	opts, err := imaging.ParseOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Serve the file
-	http.ServeFile(w, r, imagePath)
+	w.Header().Set("Cache-Control", badgeCacheControl)
+
+	format, variant := negotiateImage(w, r, badge.ImageKey, opts)
+	etag := badge.ETag
+	if variant {
+		etag = variantETag(badge.ETag, opts, format)
+	}
+	if notModified(w, r, etag, badge.UpdatedAt) {
+		return
+	}
+
+	if variant {
+		serveImageVariant(w, r, badgeStore(h.storage), badge.ImageKey, opts, format)
+		return
+	}
+
+	info, err := h.storage.StatBadgeImage(ctx, badge.ImageKey)
+	if err != nil {
+		log.Printf("Error getting badge image %s: %v", badge.ImageKey, err)
+		http.NotFound(w, r)
+		return
+	}
+
+	reader, err := h.storage.GetBadgeImage(ctx, badge.ImageKey)
+	if err != nil {
+		http.Error(w, "Failed to retrieve badge", http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", imaging.ContentType(imaging.FormatFor(badge.ImageKey)))
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	io.Copy(w, reader)
+}
+
+// lookupBadge finds a badge by id, also accepting the old file names
+func (h *BadgeHandler) lookupBadge(id string) (*database.Badge, error) {
+	badge, err := h.db.GetBadge(id)
+	if err != nil || badge != nil {
+		return badge, err
+	}
+
+	for _, suffix := range legacyBadgeSuffixes {
+		if legacyID, ok := strings.CutSuffix(id, suffix); ok {
+			return h.db.GetBadge(legacyID)
+		}
+	}
+	return nil, nil
 }
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dayquest/cdn/internal/database"
+	"github.com/dayquest/cdn/internal/imaging"
+	"github.com/dayquest/cdn/internal/storage"
+)
+
+// Badge strip limits
+const (
+	maxStripBadges     = 16
+	maxStripSize       = 256
+	defaultStripSize   = 32
+	stripOffsetsHeader = "X-Badge-Offsets"
+)
+
+// GetBadgeStrip composes several badges side by side into one image, for
+// profile headers that would otherwise fetch every badge on its own. Badges
+// are laid out in id order; the X-Badge-Offsets header maps each id to its x
+// offset, e.g. "bug_hunter=0,verified=32".
+func (h *BadgeHandler) GetBadgeStrip(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ids, err := parseStripIDs(r.URL.Query().Get("ids"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	size := defaultStripSize
+	if value := r.URL.Query().Get("size"); value != "" {
+		opts, err := imaging.ParseOptions(url.Values{"w": {value}})
+		if err != nil || opts.Width > maxStripSize {
+			http.Error(w, fmt.Sprintf("invalid size: must be a size preset up to %d", maxStripSize), http.StatusBadRequest)
+			return
+		}
+		size = opts.Width
+	}
+
+	badges, err := h.db.GetBadges(ids)
+	if err != nil {
+		log.Printf("Error getting badges: %v", err)
+		http.Error(w, "Failed to retrieve badges", http.StatusInternalServerError)
+		return
+	}
+	if len(badges) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	format, _ := negotiateImage(w, r, "strip.png", imaging.Options{})
+
+	// The cache key covers the images themselves, so replacing one badge
+	// image produces a new strip
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d:%s", size, format)
+	offsets := make([]string, len(badges))
+	for i, badge := range badges {
+		fmt.Fprintf(hash, ":%s=%s", badge.ID, badge.ETag)
+		offsets[i] = fmt.Sprintf("%s=%d", badge.ID, i*size)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil)[:16])
+	stripKey := storage.VariantKey("strips", sum+imaging.Extension(format))
+
+	w.Header().Set(stripOffsetsHeader, strings.Join(offsets, ","))
+	w.Header().Set("Access-Control-Expose-Headers", stripOffsetsHeader)
+	w.Header().Set("Cache-Control", badgeCacheControl)
+	if notModified(w, r, `"`+sum+`"`, time.Time{}) {
+		return
+	}
+
+	if info, err := h.storage.StatBadgeImage(ctx, stripKey); err == nil {
+		if reader, err := h.storage.GetBadgeImage(ctx, stripKey); err == nil {
+			defer reader.Close()
+			w.Header().Set("Content-Type", imaging.ContentType(format))
+			w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+			io.Copy(w, reader)
+			return
+		}
+	}
+
+	strip, err := h.composeStrip(r, badges, size)
+	if err != nil {
+		log.Printf("Error composing badge strip: %v", err)
+		http.Error(w, "Failed to render badge strip", http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(ctx, &buf, strip, format, imaging.DefaultQuality); err != nil {
+		log.Printf("Error encoding badge strip: %v", err)
+		http.Error(w, "Failed to render badge strip", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.storage.UploadBadgeImage(ctx, stripKey, bytes.NewReader(buf.Bytes()), imaging.ContentType(format)); err != nil {
+		log.Printf("Error caching badge strip %s: %v", stripKey, err)
+	}
+
+	w.Header().Set("Content-Type", imaging.ContentType(format))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
+
+// composeStrip draws each badge into a size x size cell, centring images too
+// small to fill it
+func (h *BadgeHandler) composeStrip(r *http.Request, badges []database.Badge, size int) (image.Image, error) {
+	strip := image.NewRGBA(image.Rect(0, 0, size*len(badges), size))
+
+	for i, badge := range badges {
+		reader, err := h.storage.GetBadgeImage(r.Context(), badge.ImageKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get badge %s: %w", badge.ID, err)
+		}
+		img, err := imaging.Decode(reader)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode badge %s: %w", badge.ID, err)
+		}
+
+		img = imaging.Resize(img, imaging.Options{Width: size, Height: size, Fit: imaging.FitCover})
+		b := img.Bounds()
+		at := image.Pt(i*size+(size-b.Dx())/2, (size-b.Dy())/2)
+		draw.Draw(strip, image.Rectangle{Min: at, Max: at.Add(b.Size())}, img, b.Min, draw.Over)
+	}
+
+	return strip, nil
+}
+
+// parseStripIDs splits, validates, sorts and deduplicates the ids parameter
+func parseStripIDs(value string) ([]string, error) {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		if !validBadgeID.MatchString(id) {
+			return nil, fmt.Errorf("invalid badge id %q", id)
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("ids is required")
+	}
+	if len(ids) > maxStripBadges {
+		return nil, fmt.Errorf("at most %d badges can be combined", maxStripBadges)
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
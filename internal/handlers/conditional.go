@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dayquest/cdn/internal/imaging"
+)
+
+// notModified sets the ETag and Last-Modified validators that are known and,
+// when the request's If-None-Match or If-Modified-Since shows the client
+// already has this representation, answers 304 Not Modified. It reports
+// whether it did. Either validator may be left empty.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence, so If-Modified-Since is only consulted
+	// when it is absent
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if etag == "" || !etagListMatches(match, etag) {
+			return false
+		}
+	} else if !unmodifiedSince(r, modified) {
+		return false
+	}
+
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// unmodifiedSince reports whether a GET or HEAD request's If-Modified-Since
+// is no earlier than modified
+func unmodifiedSince(r *http.Request, modified time.Time) bool {
+	since := r.Header.Get("If-Modified-Since")
+	if since == "" || modified.IsZero() || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	t, err := http.ParseTime(since)
+	return err == nil && !modified.Truncate(time.Second).After(t)
+}
+
+// etagListMatches applies the weak comparison If-None-Match calls for
+func etagListMatches(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// variantETag derives the ETag of an image variant from its original's, so
+// the variant changes whenever the original does
+func variantETag(etag string, opts imaging.Options, format string) string {
+	if etag == "" {
+		return ""
+	}
+	return fmt.Sprintf(`"%s-%s-%s"`, strings.Trim(etag, `"`), opts.Key(), format)
+}
+
+// contentETag derives an ETag from bytes that are rendered rather than stored
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
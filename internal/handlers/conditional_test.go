@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestETagListMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		etag string
+		want bool
+	}{
+		{name: "exact", list: `"abc"`, etag: `"abc"`, want: true},
+		{name: "different", list: `"abc"`, etag: `"xyz"`},
+		{name: "weak candidate", list: `W/"abc"`, etag: `"abc"`, want: true},
+		{name: "weak etag", list: `"abc"`, etag: `W/"abc"`, want: true},
+		{name: "both weak", list: `W/"abc"`, etag: `W/"abc"`, want: true},
+		{name: "list", list: `"one", "abc", "two"`, etag: `"abc"`, want: true},
+		{name: "list without spaces", list: `"one","abc"`, etag: `"abc"`, want: true},
+		{name: "list with weak member", list: `"one", W/"abc"`, etag: `"abc"`, want: true},
+		{name: "list without match", list: `"one", "two"`, etag: `"abc"`},
+		{name: "wildcard", list: `*`, etag: `"abc"`, want: true},
+		{name: "wildcard in list", list: `"one", *`, etag: `"abc"`, want: true},
+		{name: "unquoted is not the same tag", list: `abc`, etag: `"abc"`},
+		{name: "prefix is not a match", list: `"ab"`, etag: `"abc"`},
+		{name: "case matters", list: `"ABC"`, etag: `"abc"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagListMatches(tt.list, tt.etag); got != tt.want {
+				t.Errorf("etagListMatches(%q, %q) = %v, want %v", tt.list, tt.etag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	const etag = `"abc"`
+	modified := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+	// Stored times carry sub-second precision that Last-Modified drops
+	stored := modified.Add(400 * time.Millisecond)
+
+	at := func(d time.Duration) string { return modified.Add(d).Format(http.TimeFormat) }
+
+	tests := []struct {
+		name            string
+		method          string
+		ifNoneMatch     string
+		ifModifiedSince string
+		etag            string
+		modified        time.Time
+		want            bool
+	}{
+		{name: "no conditions", etag: etag, modified: stored},
+		{name: "matching etag", ifNoneMatch: etag, etag: etag, modified: stored, want: true},
+		{name: "weak matching etag", ifNoneMatch: `W/"abc"`, etag: etag, modified: stored, want: true},
+		{name: "stale etag", ifNoneMatch: `"old"`, etag: etag, modified: stored},
+		{name: "etag in list", ifNoneMatch: `"old", "abc"`, etag: etag, modified: stored, want: true},
+		{name: "wildcard", ifNoneMatch: "*", etag: etag, modified: stored, want: true},
+		{name: "if-none-match without an etag", ifNoneMatch: "*", modified: stored},
+		{name: "same second", ifModifiedSince: at(0), etag: etag, modified: stored, want: true},
+		{name: "later date", ifModifiedSince: at(time.Hour), etag: etag, modified: stored, want: true},
+		{name: "earlier date", ifModifiedSince: at(-time.Second), etag: etag, modified: stored},
+		{name: "unparseable date", ifModifiedSince: "yesterday", etag: etag, modified: stored},
+		{name: "date without last-modified", ifModifiedSince: at(0), etag: etag},
+		{name: "date on head", method: http.MethodHead, ifModifiedSince: at(0), modified: stored, want: true},
+		{name: "date ignored on post", method: http.MethodPost, ifModifiedSince: at(0), modified: stored},
+		{name: "stale etag overrides fresh date", ifNoneMatch: `"old"`, ifModifiedSince: at(time.Hour), etag: etag, modified: stored},
+		{name: "matching etag overrides stale date", ifNoneMatch: etag, ifModifiedSince: at(-time.Hour), etag: etag, modified: stored, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "/thumbnail/test.jpg", nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			if tt.ifModifiedSince != "" {
+				r.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+			}
+
+			w := httptest.NewRecorder()
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Header().Set("Content-Length", "1024")
+
+			if got := notModified(w, r, tt.etag, tt.modified); got != tt.want {
+				t.Fatalf("notModified = %v, want %v", got, tt.want)
+			}
+
+			if got := w.Header().Get("ETag"); got != tt.etag {
+				t.Errorf("ETag = %q, want %q", got, tt.etag)
+			}
+			wantModified := ""
+			if !tt.modified.IsZero() {
+				wantModified = at(0)
+			}
+			if got := w.Header().Get("Last-Modified"); got != wantModified {
+				t.Errorf("Last-Modified = %q, want %q", got, wantModified)
+			}
+
+			if !tt.want {
+				if w.Header().Get("Content-Type") == "" {
+					t.Errorf("Content-Type was dropped from a response that is still sent")
+				}
+				return
+			}
+			if w.Code != http.StatusNotModified {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusNotModified)
+			}
+			if w.Header().Get("Content-Type") != "" || w.Header().Get("Content-Length") != "" {
+				t.Errorf("304 carries entity headers: %v", w.Header())
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dayquest/cdn/internal/imaging"
+	"github.com/dayquest/cdn/internal/storage"
+)
+
+// imageStore is the bucket an image handler reads originals from and caches
+// its derived variants in
+type imageStore struct {
+	get    func(ctx context.Context, key string) (io.ReadCloser, error)
+	stat   func(ctx context.Context, key string) (storage.ObjectInfo, error)
+	upload func(ctx context.Context, key string, reader io.Reader, contentType string) error
+}
+
+func thumbnailStore(s storage.Storage) imageStore {
+	return imageStore{
+		get: func(ctx context.Context, key string) (io.ReadCloser, error) {
+			return s.GetThumbnail(ctx, key, 0, -1)
+		},
+		stat:   s.StatThumbnail,
+		upload: s.UploadThumbnail,
+	}
+}
+
+func profileImageStore(s storage.Storage) imageStore {
+	return imageStore{
+		get:    s.GetProfileImage,
+		stat:   s.StatProfileImage,
+		upload: s.UploadProfileImage,
+	}
+}
+
+// negotiateImage picks the format to serve key in and reports whether that
+// needs a variant rather than the stored original
+func negotiateImage(w http.ResponseWriter, r *http.Request, key string, opts imaging.Options) (string, bool) {
+	w.Header().Add("Vary", "Accept")
+
+	format := imaging.Negotiate(r.Header.Get("Accept"), key)
+	return format, !opts.IsZero() || format != imaging.FormatFor(key)
+}
+
+// serveImageVariant writes key resized by opts and encoded as format,
+// rendering and caching it on first request. Callers set any cache headers
+// beforehand.
+func serveImageVariant(w http.ResponseWriter, r *http.Request, store imageStore, key string, opts imaging.Options, format string) {
+	ctx := r.Context()
+	variantKey := storage.VariantKey(key, opts.Key()+imaging.Extension(format))
+
+	if info, err := store.stat(ctx, variantKey); err == nil {
+		reader, err := store.get(ctx, variantKey)
+		if err == nil {
+			defer reader.Close()
+			w.Header().Set("Content-Type", imaging.ContentType(format))
+			w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+			io.Copy(w, reader)
+			return
+		}
+		log.Printf("Error reading cached variant %s: %v", variantKey, err)
+	}
+
+	original, err := store.get(ctx, key)
+	if err != nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	defer original.Close()
+
+	img, err := imaging.Decode(original)
+	if err != nil {
+		log.Printf("Error decoding %s: %v", key, err)
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(ctx, &buf, imaging.Resize(img, opts), format, opts.Quality); err != nil {
+		log.Printf("Error encoding variant %s: %v", variantKey, err)
+		http.Error(w, "Failed to render image", http.StatusInternalServerError)
+		return
+	}
+
+	// A failed cache write only costs a re-render on the next request
+	if err := store.upload(ctx, variantKey, bytes.NewReader(buf.Bytes()), imaging.ContentType(format)); err != nil {
+		log.Printf("Error caching variant %s: %v", variantKey, err)
+	}
+
+	w.Header().Set("Content-Type", imaging.ContentType(format))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
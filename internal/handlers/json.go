@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// writeJSON sends v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError sends the {"status", "message"} body used for API errors
+func writeJSONError(w http.ResponseWriter, code int, status, message string) {
+	writeJSON(w, code, map[string]string{
+		"status":  status,
+		"message": message,
+	})
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+
+	"github.com/dayquest/cdn/internal/database"
+	"github.com/dayquest/cdn/internal/imaging"
+)
+
+func placeholderFromImage(img image.Image) (database.Placeholder, error) {
+	hash, err := imaging.BlurHash(img)
+	if err != nil {
+		return database.Placeholder{}, fmt.Errorf("failed to compute blurhash: %w", err)
+	}
+	return database.Placeholder{
+		BlurHash: hash,
+		Width:    img.Bounds().Dx(),
+		Height:   img.Bounds().Dy(),
+	}, nil
+}
+
+func placeholderFromBytes(data []byte) (database.Placeholder, error) {
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return database.Placeholder{}, err
+	}
+	return placeholderFromImage(img)
+}
+
+// savePlaceholder computes and stores the placeholder of an encoded image
+func savePlaceholder(db *database.DBHandler, kind, key string, data []byte) error {
+	placeholder, err := placeholderFromBytes(data)
+	if err != nil {
+		return err
+	}
+	return db.SavePlaceholder(kind, key, placeholder)
+}
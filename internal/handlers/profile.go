@@ -1,14 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/dayquest/cdn/internal/avatar"
+	"github.com/dayquest/cdn/internal/database"
+	"github.com/dayquest/cdn/internal/imaging"
 	"github.com/dayquest/cdn/internal/storage"
 	"github.com/gorilla/mux"
 )
@@ -16,15 +20,86 @@ import (
 // ProfileHandler handles profile image requests
 type ProfileHandler struct {
 	storage storage.Storage
+	db      *database.DBHandler
+	avatars *avatar.Cache
 }
 
 // NewProfileHandler creates a new ProfileHandler
-func NewProfileHandler(storage storage.Storage) *ProfileHandler {
+func NewProfileHandler(storage storage.Storage, db *database.DBHandler) *ProfileHandler {
 	return &ProfileHandler{
 		storage: storage,
+		db:      db,
+		avatars: avatar.NewCache(4096),
 	}
 }
 
+// profileImageKeys lists the keys named after a user that their profile image
+// may be stored under, in lookup order. The legacy user_<name> keys are left
+// out, since they are also the canonical keys of the user called user_<name>.
+func profileImageKeys(username string) []string {
+	return []string{
+		fmt.Sprintf("profile-pictures/%s.jpg", username),
+		fmt.Sprintf("profile-pictures/%s.png", username),
+		fmt.Sprintf("profile-pictures/%s.jpeg", username),
+	}
+}
+
+// resolveProfileImage returns the indexed image of a user, or an empty path
+// when there is none or it has gone missing. Users missing from the index are
+// looked up under their own names once, so pictures written to the bucket
+// directly are still found, and indexed when they are.
+func (h *ProfileHandler) resolveProfileImage(ctx context.Context, username string) (string, storage.ObjectInfo, error) {
+	entry, err := h.db.GetProfileImage(username)
+	if err != nil {
+		return "", storage.ObjectInfo{}, err
+	}
+	if entry == nil {
+		return h.indexProfileImage(ctx, username)
+	}
+
+	info, err := h.storage.StatProfileImage(ctx, entry.ObjectKey)
+	if err != nil {
+		log.Printf("Indexed profile image %s for %s is unavailable: %v", entry.ObjectKey, username, err)
+		return "", storage.ObjectInfo{}, nil
+	}
+	return entry.ObjectKey, info, nil
+}
+
+// indexProfileImage finds an unindexed image stored under one of the user's
+// own keys and adds it to the index. Keys another user is indexed to are
+// skipped, so a deleted picture that was shared is not brought back.
+func (h *ProfileHandler) indexProfileImage(ctx context.Context, username string) (string, storage.ObjectInfo, error) {
+	for _, imagePath := range profileImageKeys(username) {
+		info, err := h.storage.StatProfileImage(ctx, imagePath)
+		if err != nil {
+			continue
+		}
+
+		inUse, err := h.db.ProfileImageKeyInUse(imagePath, username)
+		if err != nil {
+			return "", storage.ObjectInfo{}, err
+		}
+		if inUse {
+			continue
+		}
+
+		contentType := "image/jpeg"
+		if strings.HasSuffix(imagePath, ".png") {
+			contentType = "image/png"
+		}
+		_, err = h.db.AddProfileImage(database.ProfileImage{
+			Username:    username,
+			ObjectKey:   imagePath,
+			ContentType: contentType,
+		})
+		if err != nil {
+			log.Printf("Error indexing profile image %s for %s: %v", imagePath, username, err)
+		}
+		return imagePath, info, nil
+	}
+	return "", storage.ObjectInfo{}, nil
+}
+
 // GetProfileImage serves profile image files
 func (h *ProfileHandler) GetProfileImage(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -34,60 +109,72 @@ func (h *ProfileHandler) GetProfileImage(w http.ResponseWriter, r *http.Request)
 	username = strings.ReplaceAll(username, "/", "")
 	username = strings.ReplaceAll(username, "\\", "")
 
-	// Define possible image filename patterns
-	patterns := []string{
-		fmt.Sprintf("%s.jpg", username),
-		fmt.Sprintf("%s.png", username),
-		fmt.Sprintf("%s.jpeg", username),
-		fmt.Sprintf("user_%s.jpg", username),
-		fmt.Sprintf("user_%s.png", username),
-		fmt.Sprintf("user_%s.jpeg", username),
-	}
-
-	// Try to find the profile image with one of the patterns
-	var imageReader io.ReadCloser
-	var imageFile string
-	var imageFound bool
-
-	for _, pattern := range patterns {
-		imagePath := filepath.Join("profile-pictures", pattern)
-		reader, err := h.storage.GetProfileImage(ctx, imagePath)
-		if err == nil {
-			imageReader = reader
-			imageFile = pattern
-			imageFound = true
-			break
-		}
+	opts, err := imaging.ParseOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// If no image was found, serve the default image
-	if !imageFound {
-		defaultImagePath := filepath.Join("profile-pictures", "default.jpg")
-		reader, err := h.storage.GetProfileImage(ctx, defaultImagePath)
-		if err != nil {
-			log.Printf("Error getting default profile image: %v", err)
-			http.Error(w, "Default profile image not found", http.StatusInternalServerError)
-			return
-		}
-		imageReader = reader
-		imageFile = "default.jpg"
+	// One index lookup decides which object to serve
+	imagePath, info, err := h.resolveProfileImage(ctx, username)
+	if err != nil {
+		log.Printf("Error resolving profile image for %s: %v", username, err)
+		http.Error(w, "Profile image not available", http.StatusInternalServerError)
+		return
 	}
 
-	defer imageReader.Close()
+	// Users without an image get a generated avatar
+	if imagePath == "" {
+		h.serveGeneratedAvatar(w, r, username, opts)
+		return
+	}
 
 	// Determine content type
-	contentType := "image/jpeg"
-	if strings.HasSuffix(imageFile, ".png") {
-		contentType = "image/png"
+	contentType := info.ContentType
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = "image/jpeg"
+		if strings.HasSuffix(imagePath, ".png") {
+			contentType = "image/png"
+		}
 	}
 
-	// Set cache headers
+	w.Header().Set("Content-Type", contentType)
+	setProfileCacheHeaders(w, r)
+
+	format, variant := negotiateImage(w, r, imagePath, opts)
+	etag := objectETag(info)
+	if variant {
+		etag = variantETag(etag, opts, format)
+	}
+	if notModified(w, r, etag, info.LastModified) {
+		return
+	}
+
+	if variant {
+		serveImageVariant(w, r, profileImageStore(h.storage), imagePath, opts, format)
+		return
+	}
+
+	imageReader, err := h.storage.GetProfileImage(ctx, imagePath)
+	if err != nil {
+		log.Printf("Error getting profile image %s: %v", imagePath, err)
+		http.Error(w, "Profile image not available", http.StatusInternalServerError)
+		return
+	}
+	defer imageReader.Close()
+
+	// Copy the image data to the response
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	io.Copy(w, imageReader)
+}
+
+// setProfileCacheHeaders caches profile images for a day unless ?nocache is set
+func setProfileCacheHeaders(w http.ResponseWriter, r *http.Request) {
 	cacheControl := "public, max-age=86400" // 24 hours
 	if r.URL.Query().Get("nocache") != "" {
 		cacheControl = "no-store, no-cache, must-revalidate, max-age=0"
 	}
 
-	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Cache-Control", cacheControl)
 
 	// Set a far-future expiration date if not no-cache
@@ -95,7 +182,44 @@ func (h *ProfileHandler) GetProfileImage(w http.ResponseWriter, r *http.Request)
 		expiresTime := time.Now().Add(24 * time.Hour)
 		w.Header().Set("Expires", expiresTime.Format(time.RFC1123))
 	}
+}
 
-	// Copy the image data to the response
-	io.Copy(w, imageReader)
+// GetProfilePlaceholder returns the BlurHash of the image GetProfileImage
+// would serve, so clients can paint it before the image arrives
+func (h *ProfileHandler) GetProfilePlaceholder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	username := mux.Vars(r)["username"]
+	username = strings.ReplaceAll(username, "/", "")
+	username = strings.ReplaceAll(username, "\\", "")
+
+	imagePath, _, err := h.resolveProfileImage(ctx, username)
+	if err != nil {
+		log.Printf("Error resolving profile image for %s: %v", username, err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Profile image not available")
+		return
+	}
+
+	// Placeholders of stored pictures are computed on upload or by the
+	// backfill command, so this is a plain lookup
+	var placeholder *database.Placeholder
+	if imagePath == "" {
+		placeholder, err = h.avatarPlaceholder(username)
+	} else {
+		placeholder, err = h.db.GetPlaceholder(database.PlaceholderProfile, imagePath)
+	}
+	if err != nil {
+		log.Printf("Error getting profile placeholder for %s: %v", username, err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Profile placeholder not available")
+		return
+	}
+	if placeholder == nil {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Profile placeholder not available")
+		return
+	}
+
+	setProfileCacheHeaders(w, r)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"data":   placeholder,
+	})
 }
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dayquest/cdn/internal/avatar"
+	"github.com/dayquest/cdn/internal/database"
+	"github.com/dayquest/cdn/internal/imaging"
+)
+
+// serveGeneratedAvatar writes the default avatar of a user: an identicon PNG,
+// or with ?avatar=initials an SVG of their initials. Anyone can ask for any
+// name, so generated avatars and their variants are only kept in memory and
+// never written to storage.
+func (h *ProfileHandler) serveGeneratedAvatar(w http.ResponseWriter, r *http.Request, username string, opts imaging.Options) {
+	if r.URL.Query().Get("avatar") == "initials" {
+		key := "initials/" + username
+		data, ok := h.avatars.Get(key)
+		if !ok {
+			data = avatar.InitialsSVG(username)
+			h.avatars.Add(key, data)
+		}
+
+		setProfileCacheHeaders(w, r)
+		if notModified(w, r, contentETag(data), time.Time{}) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+		return
+	}
+
+	data, err := h.identicon(username)
+	if err != nil {
+		log.Printf("Error generating avatar for %s: %v", username, err)
+		http.Error(w, "Profile image not available", http.StatusInternalServerError)
+		return
+	}
+
+	setProfileCacheHeaders(w, r)
+
+	format, variant := negotiateImage(w, r, username+".png", opts)
+	etag := contentETag(data)
+	if variant {
+		etag = variantETag(etag, opts, format)
+	}
+	if notModified(w, r, etag, time.Time{}) {
+		return
+	}
+
+	contentType := "image/png"
+	if variant {
+		if data, err = h.identiconVariant(r.Context(), username, data, opts, format); err != nil {
+			log.Printf("Error rendering avatar variant for %s: %v", username, err)
+			http.Error(w, "Failed to render image", http.StatusInternalServerError)
+			return
+		}
+		contentType = imaging.ContentType(format)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// identicon returns a user's identicon from memory or a fresh render
+func (h *ProfileHandler) identicon(username string) ([]byte, error) {
+	key := "identicon/" + username
+	if data, ok := h.avatars.Get(key); ok {
+		return data, nil
+	}
+
+	data, err := avatar.Identicon(username)
+	if err != nil {
+		return nil, err
+	}
+
+	h.avatars.Add(key, data)
+	return data, nil
+}
+
+// identiconVariant resizes or re-encodes an identicon, keeping the result in
+// the same memory cache as the original
+func (h *ProfileHandler) identiconVariant(ctx context.Context, username string, data []byte, opts imaging.Options, format string) ([]byte, error) {
+	key := "identicon/" + username + "/" + opts.Key() + imaging.Extension(format)
+	if variant, ok := h.avatars.Get(key); ok {
+		return variant, nil
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(ctx, &buf, imaging.Resize(img, opts), format, opts.Quality); err != nil {
+		return nil, err
+	}
+
+	h.avatars.Add(key, buf.Bytes())
+	return buf.Bytes(), nil
+}
+
+// avatarPlaceholder returns the placeholder of a user's identicon. It is cheap
+// to compute from the small render, so like the avatar it is not persisted.
+func (h *ProfileHandler) avatarPlaceholder(username string) (*database.Placeholder, error) {
+	data, err := h.identicon(username)
+	if err != nil {
+		return nil, err
+	}
+
+	placeholder, err := placeholderFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return &placeholder, nil
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+
+	"github.com/dayquest/cdn/internal/database"
+	"github.com/dayquest/cdn/internal/imaging"
+	"github.com/gorilla/mux"
+)
+
+// Limits and output size for uploaded profile pictures
+const (
+	maxProfileUploadBytes = 10 << 20
+	minProfileDimension   = 64
+	maxProfileDimension   = 4096
+	profileImageSize      = 512
+)
+
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// PutProfileImage stores an uploaded profile picture as the user's canonical
+// square JPEG and removes the image it replaces
+func (h *ProfileHandler) PutProfileImage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	username, ok := profileUsername(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxProfileUploadBytes))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "error", "Profile picture exceeds the upload size limit")
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "error", "Failed to read upload")
+		return
+	}
+
+	normalized, err := normalizeProfileImage(ctx, data)
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, "invalid_image", err.Error())
+		return
+	}
+
+	previous, err := h.db.GetProfileImage(username)
+	if err != nil {
+		log.Printf("Error looking up profile picture for %s: %v", username, err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Error storing profile picture")
+		return
+	}
+
+	imagePath := filepath.Join("profile-pictures", username+".jpg")
+	if err := h.storage.UploadProfileImage(ctx, imagePath, bytes.NewReader(normalized), "image/jpeg"); err != nil {
+		log.Printf("Error storing profile picture for %s: %v", username, err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Error storing profile picture")
+		return
+	}
+
+	err = h.db.SetProfileImage(database.ProfileImage{
+		Username:    username,
+		ObjectKey:   imagePath,
+		ContentType: "image/jpeg",
+	})
+	if err != nil {
+		log.Printf("Error indexing profile picture for %s: %v", username, err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Error storing profile picture")
+		return
+	}
+
+	if err := savePlaceholder(h.db, database.PlaceholderProfile, imagePath, normalized); err != nil {
+		log.Printf("Error saving profile placeholder for %s: %v", username, err)
+	}
+
+	if err := h.removeProfileImages(ctx, username, previous, imagePath); err != nil {
+		log.Printf("Error removing stale profile pictures for %s: %v", username, err)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "ok",
+		"message": "Profile picture updated",
+		"data": map[string]string{
+			"url": "/profile-pictures/" + username,
+		},
+	})
+}
+
+// DeleteProfileImage removes the stored profile picture of a user, so the
+// default is served again
+func (h *ProfileHandler) DeleteProfileImage(w http.ResponseWriter, r *http.Request) {
+	username, ok := profileUsername(w, r)
+	if !ok {
+		return
+	}
+
+	previous, err := h.db.GetProfileImage(username)
+	if err != nil {
+		log.Printf("Error looking up profile picture for %s: %v", username, err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Error deleting profile picture")
+		return
+	}
+
+	if err := h.db.DeleteProfileImage(username); err != nil {
+		log.Printf("Error unindexing profile picture for %s: %v", username, err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Error deleting profile picture")
+		return
+	}
+
+	if err := h.removeProfileImages(r.Context(), username, previous, ""); err != nil {
+		log.Printf("Error deleting profile pictures for %s: %v", username, err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Error deleting profile picture")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status":  "ok",
+		"message": "Profile picture deleted",
+	})
+}
+
+// removeProfileImages deletes the image the user was previously indexed to
+// and any under their own names, except keep, and drops the cached variants
+// of all of them. Keys another user is indexed to are left alone.
+func (h *ProfileHandler) removeProfileImages(ctx context.Context, username string, previous *database.ProfileImage, keep string) error {
+	keys := profileImageKeys(username)
+	if previous != nil {
+		keys = append([]string{previous.ObjectKey}, keys...)
+	}
+
+	seen := map[string]bool{}
+	for _, imagePath := range keys {
+		if seen[imagePath] {
+			continue
+		}
+		seen[imagePath] = true
+
+		if imagePath != keep {
+			inUse, err := h.db.ProfileImageKeyInUse(imagePath, username)
+			if err != nil {
+				return err
+			}
+			if inUse {
+				continue
+			}
+			if err := h.storage.DeleteProfileImage(ctx, imagePath); err != nil {
+				return err
+			}
+		}
+		if err := h.storage.PurgeProfileImageVariants(ctx, imagePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func profileUsername(w http.ResponseWriter, r *http.Request) (string, bool) {
+	username := mux.Vars(r)["username"]
+	if !validUsername.MatchString(username) || username == "default" {
+		writeJSONError(w, http.StatusBadRequest, "error", "Invalid username")
+		return "", false
+	}
+	return username, true
+}
+
+// normalizeProfileImage validates an upload and turns it into an upright,
+// square JPEG. Re-encoding drops EXIF and any other embedded metadata.
+func normalizeProfileImage(ctx context.Context, data []byte) ([]byte, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("body is not a JPEG, PNG or WebP image")
+	}
+	if cfg.Width < minProfileDimension || cfg.Height < minProfileDimension {
+		return nil, fmt.Errorf("image must be at least %dx%d", minProfileDimension, minProfileDimension)
+	}
+	if cfg.Width > maxProfileDimension || cfg.Height > maxProfileDimension {
+		return nil, fmt.Errorf("image must be at most %dx%d", maxProfileDimension, maxProfileDimension)
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if format == imaging.FormatJPEG {
+		img = imaging.Orient(img, imaging.Orientation(data))
+	}
+
+	img = imaging.Resize(imaging.Flatten(img), imaging.Options{
+		Width:  profileImageSize,
+		Height: profileImageSize,
+		Fit:    imaging.FitCover,
+	})
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(ctx, &buf, img, imaging.FormatJPEG, 90); err != nil {
+		return nil, fmt.Errorf("failed to encode profile picture: %v", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dayquest/cdn/internal/storage"
+)
+
+// maxRanges bounds how many ranges a single request may ask for. Longer lists
+// are ignored and the full object is served instead.
+const maxRanges = 16
+
+var errUnsatisfiableRange = errors.New("requested range not satisfiable")
+
+// byteRange is a satisfiable range of an object, with end inclusive
+type byteRange struct {
+	start, end int64
+}
+
+func (br byteRange) length() int64 {
+	return br.end - br.start + 1
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.end, size)
+}
+
+// parseRange evaluates a Range header against an object of the given size as
+// described in RFC 9110 section 14.2. It returns no ranges when the header is
+// absent or malformed, in which case the whole object should be served, and
+// errUnsatisfiableRange when none of the requested ranges overlap the object.
+// Overlapping and adjacent ranges are merged.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	unit, set, ok := strings.Cut(header, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	specs := 0
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		if specs++; specs > maxRanges {
+			return nil, nil
+		}
+
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+
+		if first == "" {
+			// A suffix range asks for the final n bytes
+			n, ok := parseRangePos(last)
+			if !ok {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			ranges = append(ranges, byteRange{start: size - n, end: size - 1})
+			continue
+		}
+
+		start, ok := parseRangePos(first)
+		if !ok {
+			return nil, nil
+		}
+		end := size - 1
+		if last != "" {
+			if end, ok = parseRangePos(last); !ok || end < start {
+				return nil, nil
+			}
+			if end >= size {
+				end = size - 1
+			}
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, end: end})
+	}
+
+	if specs == 0 {
+		return nil, nil
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return coalesceRanges(ranges), nil
+}
+
+// coalesceRanges merges ranges that overlap or touch, as RFC 9110 allows, so
+// a list such as bytes=0-,0-,0- cannot make the response larger than the object
+func coalesceRanges(ranges []byteRange) []byteRange {
+	if len(ranges) < 2 {
+		return ranges
+	}
+
+	sorted := append([]byteRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+
+	merged := sorted[:1]
+	for _, br := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if br.start > last.end+1 {
+			merged = append(merged, br)
+			continue
+		}
+		if br.end > last.end {
+			last.end = br.end
+		}
+	}
+	return merged
+}
+
+// parseRangePos parses a non-negative decimal position, rejecting the signs
+// and whitespace strconv would otherwise tolerate
+func parseRangePos(s string) (int64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+// ifRangeMatches reports whether the request's If-Range precondition, if any,
+// still holds for the object. When it does not, the Range header must be
+// ignored so the client receives the current representation in full.
+func ifRangeMatches(r *http.Request, obj storage.ObjectInfo) bool {
+	value := strings.TrimSpace(r.Header.Get("If-Range"))
+	if value == "" {
+		return true
+	}
+
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "W/") {
+		// If-Range requires a strong comparison, so weak tags never match
+		return obj.ETag != "" && value == objectETag(obj)
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil || obj.LastModified.IsZero() {
+		return false
+	}
+	return obj.LastModified.Truncate(time.Second).Equal(date)
+}
+
+// objectETag returns the object's entity tag in its quoted header form
+func objectETag(obj storage.ObjectInfo) string {
+	if obj.ETag == "" {
+		return ""
+	}
+	return strconv.Quote(strings.Trim(obj.ETag, `"`))
+}
+
+// serveRanges writes the object, or the parts of it the request asked for,
+// using open to read each range from storage. A single range is answered with
+// 206 and a Content-Range header; several are sent as multipart/byteranges.
+func serveRanges(w http.ResponseWriter, r *http.Request, obj storage.ObjectInfo, contentType string, open func(start, end int64) (io.ReadCloser, error)) {
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	var ranges []byteRange
+	if header := r.Header.Get("Range"); header != "" && ifRangeMatches(r, obj) {
+		var err error
+		if ranges, err = parseRange(header, obj.Size); err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", obj.Size))
+			http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+	}
+
+	switch len(ranges) {
+	case 0:
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+		if obj.Size == 0 {
+			return
+		}
+		writeRange(w, byteRange{start: 0, end: obj.Size - 1}, http.StatusOK, open)
+	case 1:
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(ranges[0].length(), 10))
+		w.Header().Set("Content-Range", ranges[0].contentRange(obj.Size))
+		writeRange(w, ranges[0], http.StatusPartialContent, open)
+	default:
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+		w.Header().Set("Content-Length", strconv.FormatInt(multipartRangesSize(ranges, obj.Size, contentType, mw.Boundary()), 10))
+		w.WriteHeader(http.StatusPartialContent)
+
+		for _, br := range ranges {
+			part, err := mw.CreatePart(rangePartHeader(br, obj.Size, contentType))
+			if err != nil {
+				log.Printf("Error writing range part: %v", err)
+				return
+			}
+			reader, err := open(br.start, br.end)
+			if err != nil {
+				// The status line is already out, so all we can do is cut
+				// the response short
+				log.Printf("Error opening range %d-%d: %v", br.start, br.end, err)
+				return
+			}
+			err = copyRange(part, reader)
+			reader.Close()
+			if err != nil {
+				log.Printf("Error streaming range: %v", err)
+				return
+			}
+		}
+		if err := mw.Close(); err != nil {
+			log.Printf("Error finishing multipart response: %v", err)
+		}
+	}
+}
+
+// writeRange opens a single range and streams it with the given status
+func writeRange(w http.ResponseWriter, br byteRange, status int, open func(start, end int64) (io.ReadCloser, error)) {
+	reader, err := open(br.start, br.end)
+	if err != nil {
+		log.Printf("Error opening range %d-%d: %v", br.start, br.end, err)
+		w.Header().Del("Content-Length")
+		w.Header().Del("Content-Range")
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	w.WriteHeader(status)
+	if err := copyRange(w, reader); err != nil {
+		log.Printf("Error streaming range: %v", err)
+	}
+}
+
+func copyRange(w io.Writer, reader io.Reader) error {
+	buffer := make([]byte, 256*1024)
+	_, err := io.CopyBuffer(w, reader, buffer)
+	return err
+}
+
+func rangePartHeader(br byteRange, size int64, contentType string) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {br.contentRange(size)},
+		"Content-Type":  {contentType},
+	}
+}
+
+// multipartRangesSize computes the exact length of a multipart/byteranges
+// body by writing its framing with empty parts and adding the range lengths
+func multipartRangesSize(ranges []byteRange, size int64, contentType, boundary string) int64 {
+	var counter countingWriter
+	mw := multipart.NewWriter(&counter)
+	mw.SetBoundary(boundary)
+
+	var total int64
+	for _, br := range ranges {
+		mw.CreatePart(rangePartHeader(br, size, contentType))
+		total += br.length()
+	}
+	mw.Close()
+	return total + int64(counter)
+}
+
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
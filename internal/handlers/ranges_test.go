@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dayquest/cdn/internal/storage"
+)
+
+func TestParseRange(t *testing.T) {
+	const size = 1000
+
+	tests := []struct {
+		name   string
+		header string
+		want   []byteRange
+		err    error
+	}{
+		{name: "suffix", header: "bytes=-500", want: []byteRange{{500, 999}}},
+		{name: "suffix longer than object", header: "bytes=-5000", want: []byteRange{{0, 999}}},
+		{name: "empty suffix", header: "bytes=-0", err: errUnsatisfiableRange},
+		{name: "open ended", header: "bytes=500-", want: []byteRange{{500, 999}}},
+		{name: "closed", header: "bytes=0-99", want: []byteRange{{0, 99}}},
+		{name: "single byte", header: "bytes=0-0", want: []byteRange{{0, 0}}},
+		{name: "end past eof is clamped", header: "bytes=900-5000", want: []byteRange{{900, 999}}},
+		{name: "start past eof", header: "bytes=1000-", err: errUnsatisfiableRange},
+		{name: "all ranges past eof", header: "bytes=1000-1100,2000-", err: errUnsatisfiableRange},
+		{name: "unsatisfiable ranges are dropped", header: "bytes=0-9,2000-", want: []byteRange{{0, 9}}},
+		{name: "end before start", header: "bytes=5-1"},
+		{name: "multiple", header: "bytes=0-9, 20-29", want: []byteRange{{0, 9}, {20, 29}}},
+		{name: "multiple are sorted", header: "bytes=500-599,0-99", want: []byteRange{{0, 99}, {500, 599}}},
+		{name: "overlapping", header: "bytes=0-499,250-749", want: []byteRange{{0, 749}}},
+		{name: "adjacent", header: "bytes=0-9,10-19", want: []byteRange{{0, 19}}},
+		{name: "repeated whole object", header: "bytes=0-,0-,0-", want: []byteRange{{0, 999}}},
+		{name: "suffix overlapping prefix", header: "bytes=0-599,-500", want: []byteRange{{0, 999}}},
+		{name: "empty list elements", header: "bytes=,0-9,", want: []byteRange{{0, 9}}},
+		{name: "unit is case insensitive", header: "Bytes=0-9", want: []byteRange{{0, 9}}},
+		{name: "unknown unit", header: "items=0-9"},
+		{name: "missing equals", header: "bytes 0-9"},
+		{name: "no ranges", header: "bytes="},
+		{name: "missing dash", header: "bytes=10"},
+		{name: "not a number", header: "bytes=a-b"},
+		{name: "signed position", header: "bytes=+1-2"},
+		{name: "negative suffix", header: "bytes=--5"},
+		{name: "one malformed spec spoils the set", header: "bytes=0-9,x-"},
+		{name: "too many ranges", header: "bytes=" + strings.Repeat("0-0,", maxRanges) + "0-0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRange(tt.header, size)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseRange(%q) error = %v, want %v", tt.header, err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRange(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRangeEmptyObject(t *testing.T) {
+	for _, header := range []string{"bytes=0-", "bytes=-10"} {
+		if _, err := parseRange(header, 0); !errors.Is(err, errUnsatisfiableRange) {
+			t.Errorf("parseRange(%q, 0) error = %v, want %v", header, err, errUnsatisfiableRange)
+		}
+	}
+}
+
+const rangeTestContent = "0123456789abcdefghij"
+
+var rangeTestModified = time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+func serveRangeTest(t *testing.T, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+
+	obj := storage.ObjectInfo{
+		Size:         int64(len(rangeTestContent)),
+		ETag:         "abc123",
+		LastModified: rangeTestModified.Add(400 * time.Millisecond),
+	}
+	open := func(start, end int64) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(rangeTestContent[start : end+1])), nil
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/video/test.mp4", nil)
+	r.Header = header
+	w := httptest.NewRecorder()
+	serveRanges(w, r, obj, "video/mp4", open)
+	return w
+}
+
+func TestServeRanges(t *testing.T) {
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		ifRange      string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{name: "no range", status: http.StatusOK, body: rangeTestContent},
+		{name: "suffix", rangeHeader: "bytes=-5", status: http.StatusPartialContent, body: "fghij", contentRange: "bytes 15-19/20"},
+		{name: "open ended", rangeHeader: "bytes=15-", status: http.StatusPartialContent, body: "fghij", contentRange: "bytes 15-19/20"},
+		{name: "malformed serves everything", rangeHeader: "bytes=5-1", status: http.StatusOK, body: rangeTestContent},
+		{name: "unsatisfiable", rangeHeader: "bytes=20-", status: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */20"},
+		{name: "overlapping ranges become one", rangeHeader: "bytes=0-4,2-9", status: http.StatusPartialContent, body: "0123456789", contentRange: "bytes 0-9/20"},
+		{name: "if-range current etag", rangeHeader: "bytes=0-1", ifRange: `"abc123"`, status: http.StatusPartialContent, body: "01", contentRange: "bytes 0-1/20"},
+		{name: "if-range stale etag", rangeHeader: "bytes=0-1", ifRange: `"old"`, status: http.StatusOK, body: rangeTestContent},
+		{name: "if-range weak etag", rangeHeader: "bytes=0-1", ifRange: `W/"abc123"`, status: http.StatusOK, body: rangeTestContent},
+		{name: "if-range current date", rangeHeader: "bytes=0-1", ifRange: rangeTestModified.Format(http.TimeFormat), status: http.StatusPartialContent, body: "01", contentRange: "bytes 0-1/20"},
+		{name: "if-range stale date", rangeHeader: "bytes=0-1", ifRange: rangeTestModified.Add(-time.Hour).Format(http.TimeFormat), status: http.StatusOK, body: rangeTestContent},
+		{name: "if-range garbage", rangeHeader: "bytes=0-1", ifRange: "yesterday", status: http.StatusOK, body: rangeTestContent},
+		{name: "stale if-range skips unsatisfiable range", rangeHeader: "bytes=50-", ifRange: `"old"`, status: http.StatusOK, body: rangeTestContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.rangeHeader != "" {
+				header.Set("Range", tt.rangeHeader)
+			}
+			if tt.ifRange != "" {
+				header.Set("If-Range", tt.ifRange)
+			}
+
+			w := serveRangeTest(t, header)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+			}
+			if w.Header().Get("Accept-Ranges") != "bytes" {
+				t.Errorf("Accept-Ranges = %q, want bytes", w.Header().Get("Accept-Ranges"))
+			}
+			if tt.status == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if got := w.Body.String(); got != tt.body {
+				t.Errorf("body = %q, want %q", got, tt.body)
+			}
+			if got, want := w.Header().Get("Content-Length"), len(tt.body); got != strconv.Itoa(want) {
+				t.Errorf("Content-Length = %s, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestServeRangesMultipart(t *testing.T) {
+	header := http.Header{}
+	header.Set("Range", "bytes=10-12,0-1,-2")
+	w := serveRangeTest(t, header)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusPartialContent)
+	}
+	if got, want := w.Header().Get("Content-Length"), strconv.Itoa(w.Body.Len()); got != want {
+		t.Errorf("Content-Length = %s, body is %s bytes", got, want)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type = %q, want multipart/byteranges", w.Header().Get("Content-Type"))
+	}
+
+	want := []struct {
+		contentRange string
+		body         string
+	}{
+		{"bytes 0-1/20", "01"},
+		{"bytes 10-12/20", "abc"},
+		{"bytes 18-19/20", "ij"},
+	}
+
+	reader := multipart.NewReader(w.Body, params["boundary"])
+	for i, part := range want {
+		p, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if got := p.Header.Get("Content-Range"); got != part.contentRange {
+			t.Errorf("part %d Content-Range = %q, want %q", i, got, part.contentRange)
+		}
+		if got := p.Header.Get("Content-Type"); got != "video/mp4" {
+			t.Errorf("part %d Content-Type = %q, want video/mp4", i, got)
+		}
+		body, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if string(body) != part.body {
+			t.Errorf("part %d body = %q, want %q", i, body, part.body)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("expected %d parts, got more (err = %v)", len(want), err)
+	}
+}
@@ -3,42 +3,100 @@ package handlers
 import (
     "io"
     "net/http"
-    "strconv"
+    "strings"
 
+    "github.com/dayquest/cdn/internal/database"
+    "github.com/dayquest/cdn/internal/imaging"
     "github.com/dayquest/cdn/internal/storage"
     "github.com/gorilla/mux"
 )
 
 type ThumbnailHandler struct {
     storage storage.Storage
+    db      *database.DBHandler
 }
 
-func NewThumbnailHandler(s storage.Storage) *ThumbnailHandler {
-    return &ThumbnailHandler{storage: s}
+func NewThumbnailHandler(s storage.Storage, db *database.DBHandler) *ThumbnailHandler {
+    return &ThumbnailHandler{storage: s, db: db}
 }
 
 func (h *ThumbnailHandler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
-    ctx := r.Context()
-
     thumbnailName := mux.Vars(r)["thumbnail"]
 
-    objInfo, err := h.storage.StatThumbnail(ctx, thumbnailName)
+    opts, err := imaging.ParseOptions(r.URL.Query())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    objInfo, err := h.storage.StatThumbnail(r.Context(), thumbnailName)
     if err != nil {
         http.Error(w, "Thumbnail not found", http.StatusNotFound)
         return
     }
 
-    w.Header().Set("Content-Type", "image/jpeg")
-    w.Header().Set("Content-Length", strconv.FormatInt(objInfo.Size, 10))
+    // Variants are validated against the original they are rendered from
+    format, variant := negotiateImage(w, r, thumbnailName, opts)
+    etag := objectETag(objInfo)
+    if variant {
+        etag = variantETag(etag, opts, format)
+    }
+    if notModified(w, r, etag, objInfo.LastModified) {
+        return
+    }
+
+    if variant {
+        serveImageVariant(w, r, thumbnailStore(h.storage), thumbnailName, opts, format)
+        return
+    }
+
+    h.serveThumbnailObject(w, r, thumbnailName, objInfo, "image/jpeg")
+}
+
+// GetThumbnailAsset serves the files stored under a video's prefix in the
+// thumbnail bucket, such as storyboard sprites and their WebVTT index
+func (h *ThumbnailHandler) GetThumbnailAsset(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    asset := vars["asset"]
+
+    if strings.Contains(asset, "..") {
+        http.Error(w, "Invalid asset path", http.StatusBadRequest)
+        return
+    }
+
+    contentType := storage.ThumbnailAssetContentType(asset)
+    if contentType == "" {
+        http.Error(w, "Unsupported asset type", http.StatusNotFound)
+        return
+    }
 
-    reader, err := h.storage.GetThumbnail(ctx, thumbnailName, 0, objInfo.Size-1)
+    objectName := vars["video"] + "/" + asset
+    objInfo, err := h.storage.StatThumbnail(r.Context(), objectName)
     if err != nil {
-        http.Error(w, "Failed to retrieve thumbnail", http.StatusInternalServerError)
+        http.Error(w, "Thumbnail not found", http.StatusNotFound)
         return
     }
-    defer reader.Close()
 
-    if _, err := io.Copy(w, reader); err != nil {
-        http.Error(w, "Failed to stream thumbnail", http.StatusInternalServerError)
+    // Versioned assets never change under their key. The rest are rewritten
+    // under the same keys by reprocessing, so caches have to revalidate; an
+    // unchanged asset costs no more than a 304.
+    if storage.VersionedAsset(asset) {
+        w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+    } else {
+        w.Header().Set("Cache-Control", "public, no-cache")
+    }
+    if notModified(w, r, objectETag(objInfo), objInfo.LastModified) {
+        return
     }
+
+    h.serveThumbnailObject(w, r, objectName, objInfo, contentType)
+}
+
+// serveThumbnailObject streams a stored object, honouring Range requests so
+// players can seek within preview clips
+func (h *ThumbnailHandler) serveThumbnailObject(w http.ResponseWriter, r *http.Request, objectName string, objInfo storage.ObjectInfo, contentType string) {
+    ctx := r.Context()
+    serveRanges(w, r, objInfo, contentType, func(start, end int64) (io.ReadCloser, error) {
+        return h.storage.GetThumbnail(ctx, objectName, start, end)
+    })
 }
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dayquest/cdn/internal/database"
+	"github.com/dayquest/cdn/internal/imaging"
+	"github.com/dayquest/cdn/internal/storage"
+	"github.com/gorilla/mux"
+)
+
+// Limits for custom thumbnails uploaded by creators
+const (
+	maxThumbnailUploadBytes = 10 << 20
+	minThumbnailDimension   = 64
+	maxThumbnailDimension   = 8192
+)
+
+// ListThumbnailCandidates returns the poster frames a creator can choose from
+func (h *ThumbnailHandler) ListThumbnailCandidates(w http.ResponseWriter, r *http.Request) {
+	videoName := mux.Vars(r)["video"]
+
+	var candidates []map[string]interface{}
+	for n := 0; n <= storage.ThumbnailCandidates; n++ {
+		key := storage.ThumbnailCandidateKey(videoName, n)
+		if _, err := h.storage.StatThumbnail(r.Context(), key); err != nil {
+			continue
+		}
+		candidates = append(candidates, map[string]interface{}{
+			"candidate": n,
+			"url":       "/thumbnail/" + key,
+		})
+	}
+
+	if len(candidates) == 0 {
+		writeJSONError(w, http.StatusNotFound, "not_found", "No thumbnail candidates for video")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"data":   candidates,
+	})
+}
+
+// SetThumbnail replaces a video's canonical thumbnail. A JSON body of
+// {"candidate": n} picks one of the generated candidates; a JPEG or PNG body
+// is validated and stored as a custom thumbnail.
+func (h *ThumbnailHandler) SetThumbnail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	videoName := mux.Vars(r)["video"]
+	thumbnailKey := storage.StreamPrefix(videoName) + ".jpg"
+
+	// Only videos that made it through processing have a thumbnail to replace
+	if _, err := h.storage.StatThumbnail(ctx, thumbnailKey); err != nil {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Video not found")
+		return
+	}
+
+	var thumbnail []byte
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			Candidate *int `json:"candidate"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&body); err != nil || body.Candidate == nil {
+			writeJSONError(w, http.StatusBadRequest, "error", `Expected a body of {"candidate": n}`)
+			return
+		}
+		if *body.Candidate < 0 || *body.Candidate > storage.ThumbnailCandidates {
+			writeJSONError(w, http.StatusBadRequest, "error", "Candidate out of range")
+			return
+		}
+
+		data, err := h.readThumbnail(r, storage.ThumbnailCandidateKey(videoName, *body.Candidate))
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, "not_found", "Thumbnail candidate not found")
+			return
+		}
+		thumbnail = data
+	} else {
+		data, err := decodeThumbnailUpload(http.MaxBytesReader(w, r.Body, maxThumbnailUploadBytes))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, "error", "Thumbnail exceeds the upload size limit")
+				return
+			}
+			writeJSONError(w, http.StatusUnprocessableEntity, "invalid_image", err.Error())
+			return
+		}
+		thumbnail = data
+	}
+
+	if err := h.storage.UploadThumbnail(ctx, thumbnailKey, bytes.NewReader(thumbnail), "image/jpeg"); err != nil {
+		log.Printf("Error storing thumbnail for %s: %v", videoName, err)
+		writeJSONError(w, http.StatusInternalServerError, "error", "Error storing thumbnail")
+		return
+	}
+	if err := h.storage.PurgeThumbnailVariants(ctx, thumbnailKey); err != nil {
+		log.Printf("Error purging thumbnail variants for %s: %v", videoName, err)
+	}
+	if err := savePlaceholder(h.db, database.PlaceholderThumbnail, thumbnailKey, thumbnail); err != nil {
+		log.Printf("Error saving thumbnail placeholder for %s: %v", videoName, err)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "ok",
+		"message": "Thumbnail updated",
+		"data": map[string]string{
+			"thumbnailUrl": "/thumbnail/" + thumbnailKey,
+		},
+	})
+}
+
+func (h *ThumbnailHandler) readThumbnail(r *http.Request, key string) ([]byte, error) {
+	info, err := h.storage.StatThumbnail(r.Context(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := h.storage.GetThumbnail(r.Context(), key, 0, info.Size-1)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// decodeThumbnailUpload checks an uploaded image and re-encodes it as JPEG,
+// which also drops any metadata the original carried
+func decodeThumbnailUpload(body io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the header first so oversized images are never decoded
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("body is not a JPEG or PNG image")
+	}
+	if cfg.Width < minThumbnailDimension || cfg.Height < minThumbnailDimension {
+		return nil, fmt.Errorf("image must be at least %dx%d", minThumbnailDimension, minThumbnailDimension)
+	}
+	if cfg.Width > maxThumbnailDimension || cfg.Height > maxThumbnailDimension {
+		return nil, fmt.Errorf("image must be at most %dx%d", maxThumbnailDimension, maxThumbnailDimension)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s image: %v", format, err)
+	}
+
+	// The re-encoded JPEG carries no EXIF, so phone photos have to be turned
+	// upright here or they end up sideways
+	if format == "jpeg" {
+		img = imaging.Orient(img, imaging.Orientation(data))
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+	return buf.Bytes(), nil
+}
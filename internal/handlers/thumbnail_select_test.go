@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// withOrientation inserts an EXIF segment carrying the given orientation
+// right after a JPEG's start of image marker
+func withOrientation(t *testing.T, data []byte, orientation byte) []byte {
+	t.Helper()
+
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2a, 0x00, 0x00, 0x00, 0x08, // header, IFD at 8
+		0x00, 0x01, // one entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, orientation, 0x00, 0x00, // orientation, SHORT
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	size := len(segment) + 2
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, 0xFF, 0xE1, byte(size>>8), byte(size))
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestDecodeThumbnailUploadOrientation(t *testing.T) {
+	// A landscape frame with a dark left half, so rotations can be told apart
+	src := image.NewRGBA(image.Rect(0, 0, 160, 90))
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 160; x++ {
+			c := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			if x < 80 {
+				c = color.RGBA{A: 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+	var plain bytes.Buffer
+	if err := jpeg.Encode(&plain, src, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		orientation byte
+		width       int
+		height      int
+		// darkAt is a point that should still be in the dark half
+		darkAt image.Point
+	}{
+		{name: "upright", orientation: 1, width: 160, height: 90, darkAt: image.Pt(10, 45)},
+		{name: "rotated 180", orientation: 3, width: 160, height: 90, darkAt: image.Pt(150, 45)},
+		{name: "rotated 90 clockwise", orientation: 6, width: 90, height: 160, darkAt: image.Pt(45, 10)},
+		{name: "rotated 90 counterclockwise", orientation: 8, width: 90, height: 160, darkAt: image.Pt(45, 150)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := decodeThumbnailUpload(bytes.NewReader(withOrientation(t, plain.Bytes(), tt.orientation)))
+			if err != nil {
+				t.Fatalf("decodeThumbnailUpload: %v", err)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("output is not a JPEG: %v", err)
+			}
+
+			if b := img.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Fatalf("size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.width, tt.height)
+			}
+			if r, _, _, _ := img.At(tt.darkAt.X, tt.darkAt.Y).RGBA(); r > 0x4000 {
+				t.Errorf("pixel at %v is light, the image was not turned upright", tt.darkAt)
+			}
+		})
+	}
+}
@@ -5,11 +5,12 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"mime"
 	"net/http"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dayquest/cdn/internal/config"
 	"github.com/dayquest/cdn/internal/database"
@@ -21,6 +22,7 @@ type VideoHandler struct {
 	storage storage.Storage
 	config  *config.Config
 	db      *database.DBHandler
+	events  *database.EventListener
 }
 
 type seekableReadCloser struct {
@@ -45,11 +47,12 @@ func (s *seekableReadCloser) Seek(offset int64, whence int) (int64, error) {
 	return s.offset, nil
 }
 
-func NewVideoHandler(storage storage.Storage, cfg *config.Config, db *database.DBHandler) *VideoHandler {
+func NewVideoHandler(storage storage.Storage, cfg *config.Config, db *database.DBHandler, events *database.EventListener) *VideoHandler {
 	return &VideoHandler{
 		storage: storage,
 		config:  cfg,
 		db:      db,
+		events:  events,
 	}
 }
 
@@ -98,28 +101,45 @@ func (h *VideoHandler) GetVideoMetadata(w http.ResponseWriter, r *http.Request)
 
 	switch status {
 	case database.StatusPending:
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusAccepted)
-		json.NewEncoder(w).Encode(map[string]string{
+		response := map[string]interface{}{
 			"status":  "pending",
 			"message": "Video is pending processing",
-		})
-		return
-	case database.StatusProcessing:
+		}
+		if progress := h.progressPayload(videoID); progress != nil {
+			response["progress"] = progress
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusAccepted)
-		json.NewEncoder(w).Encode(map[string]string{
+		json.NewEncoder(w).Encode(response)
+		return
+	case database.StatusProcessing:
+		response := map[string]interface{}{
 			"status":  "processing",
 			"message": "Video is currently being processed",
-		})
+		}
+		if progress := h.progressPayload(videoID); progress != nil {
+			response["progress"] = progress
+		}
+		if media := h.mediaInfo(videoID); media != nil {
+			response["media"] = media
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(response)
 		return
 	case database.StatusFailed:
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		json.NewEncoder(w).Encode(map[string]string{
+		response := map[string]string{
 			"status":  "failed",
 			"message": "Video processing failed",
-		})
+		}
+		if progress, err := h.db.GetJobProgress(videoID); err != nil {
+			log.Printf("Error getting job progress: %v", err)
+		} else if progress != nil && progress.LastError != "" {
+			response["reason"] = progress.LastError
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(response)
 		return
 	case database.StatusUnknown:
 		w.Header().Set("Content-Type", "application/json")
@@ -144,19 +164,99 @@ func (h *VideoHandler) GetVideoMetadata(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	data := map[string]interface{}{
+		"size":        obj.Size,
+		"contentType": obj.ContentType,
+		"filePath":    videoName,
+		"cdnUrl":      fmt.Sprintf("/video/%s", videoName),
+	}
+
+	if media := h.mediaInfo(videoID); media != nil {
+		data["media"] = media
+	}
+
+	masterPlaylist := storage.StreamPrefix(videoName) + "/" + storage.HLSMasterPlaylist
+	if _, err := h.storage.StatVideo(r.Context(), masterPlaylist); err == nil {
+		data["hlsUrl"] = fmt.Sprintf("/video/%s", masterPlaylist)
+	}
+
+	dashManifest := storage.StreamPrefix(videoName) + "/" + storage.DASHManifest
+	if _, err := h.storage.StatVideo(r.Context(), dashManifest); err == nil {
+		data["dashUrl"] = fmt.Sprintf("/video/%s", dashManifest)
+	}
+
+	// Placeholders are computed when a thumbnail is written, so this is a
+	// plain lookup; a video without a thumbnail simply has none
+	thumbnailKey := storage.StreamPrefix(videoName) + ".jpg"
+	if placeholder, err := h.db.GetPlaceholder(database.PlaceholderThumbnail, thumbnailKey); err != nil {
+		log.Printf("Error getting thumbnail placeholder: %v", err)
+	} else if placeholder != nil {
+		data["placeholder"] = placeholder
+	}
+
+	storyboard := storage.StreamPrefix(videoName) + "/" + storage.StoryboardVTT
+	if _, err := h.storage.StatThumbnail(r.Context(), storyboard); err == nil {
+		data["storyboardUrl"] = fmt.Sprintf("/thumbnail/%s", storyboard)
+	}
+
+	// Preview keys are versioned by content, so only the database knows the
+	// current ones
+	if previews, err := h.db.GetPreviews(videoName); err != nil {
+		log.Printf("Error getting preview clips: %v", err)
+	} else if len(previews) > 0 {
+		preview := make(map[string]string, len(previews))
+		for format, key := range previews {
+			preview[format] = fmt.Sprintf("/thumbnail/%s", key)
+		}
+		data["preview"] = preview
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":  "completed",
 		"message": "Video is ready",
-		"data": map[string]interface{}{
-			"size":        obj.Size,
-			"contentType": obj.ContentType,
-			"filePath":    videoName,
-			"cdnUrl":      fmt.Sprintf("/video/%s", videoName),
-		},
+		"data":    data,
 	})
 }
 
+// progressPayload describes how far along the job for a pending or processing
+// video is. It returns nil when nothing has been reported yet.
+func (h *VideoHandler) progressPayload(videoID string) map[string]interface{} {
+	progress, err := h.db.GetJobProgress(videoID)
+	if err != nil {
+		log.Printf("Error getting job progress: %v", err)
+		return nil
+	}
+	if progress == nil {
+		return nil
+	}
+
+	payload := map[string]interface{}{
+		"attempts": progress.Attempts,
+	}
+	if progress.Stage != "" {
+		payload["stage"] = progress.Stage
+		payload["percent"] = math.Round(progress.Percent*10) / 10
+		payload["updatedAt"] = progress.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	if progress.ETA > 0 {
+		payload["etaSeconds"] = int(progress.ETA / time.Second)
+	}
+
+	return payload
+}
+
+// mediaInfo returns the probed properties of a video, or nil if it has not
+// been probed yet
+func (h *VideoHandler) mediaInfo(videoID string) *database.MediaInfo {
+	info, err := h.db.GetMediaInfo(videoID)
+	if err != nil {
+		log.Printf("Error getting media info: %v", err)
+		return nil
+	}
+	return info
+}
+
 func (h *VideoHandler) StreamVideo(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	videoName := mux.Vars(r)["video"]
@@ -188,23 +288,43 @@ func (h *VideoHandler) StreamVideo(w http.ResponseWriter, r *http.Request) {
 	h.streamVideoFile(w, r, videoName, obj)
 }
 
+// StreamVideoAsset serves files stored under a video's stream prefix, such as
+// HLS playlists and segments
+func (h *VideoHandler) StreamVideoAsset(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	objectName := vars["video"] + "/" + vars["asset"]
+
+	if strings.Contains(objectName, "..") {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	obj, err := h.storage.StatVideo(r.Context(), objectName)
+	if err != nil {
+		http.Error(w, "Video not found", http.StatusNotFound)
+		return
+	}
+
+	h.streamVideoFile(w, r, objectName, obj)
+}
+
 func (h *VideoHandler) streamVideoFile(w http.ResponseWriter, r *http.Request, videoName string, obj storage.ObjectInfo) {
 	ctx := r.Context()
 
-	w.Header().Set("Accept-Ranges", "bytes")
-
+	ext := strings.ToLower(filepath.Ext(videoName))
 	contentType := obj.ContentType
-	if contentType == "" {
-		ext := strings.ToLower(filepath.Ext(videoName))
+	if ext == ".mp4" || ext == ".temp" {
+		contentType = "video/mp4"
+	} else if contentType == "" {
 		switch ext {
-		case ".mp4":
-			contentType = "video/mp4"
-		case ".temp":
-			contentType = "video/mp4"
 		case ".m3u8":
 			contentType = "application/vnd.apple.mpegurl"
 		case ".ts":
 			contentType = "video/MP2T"
+		case ".mpd":
+			contentType = "application/dash+xml"
+		case ".m4s":
+			contentType = "video/iso.segment"
 		default:
 			contentType = mime.TypeByExtension(ext)
 			if contentType == "" {
@@ -212,63 +332,30 @@ func (h *VideoHandler) streamVideoFile(w http.ResponseWriter, r *http.Request, v
 			}
 		}
 	}
-	w.Header().Set("Content-Type", contentType)
-
-	start, end := parseRangeHeader(r, obj.Size)
-	if start >= obj.Size {
-		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", obj.Size))
-		http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
-		return
+	if cacheControl := streamCacheControl(videoName); cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
 	}
-
-	rangeSize := end - start + 1
-	w.Header().Set("Content-Length", strconv.FormatInt(rangeSize, 10))
-
-	if strings.HasSuffix(strings.ToLower(videoName), ".mp4") || strings.HasSuffix(strings.ToLower(videoName), ".temp") {
-		w.Header().Set("Content-Type", "video/mp4")
-		if start > 0 || end < obj.Size-1 {
-			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, obj.Size))
-			w.WriteHeader(http.StatusPartialContent)
-		}
-	} else if start > 0 || end < obj.Size-1 {
-		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, obj.Size))
-		w.WriteHeader(http.StatusPartialContent)
-	}
-
-	reader, err := h.storage.GetVideo(ctx, videoName, start, end)
-	if err != nil {
-		http.Error(w, "Error reading video", http.StatusInternalServerError)
+	if notModified(w, r, objectETag(obj), obj.LastModified) {
 		return
 	}
-	defer reader.Close()
 
-	buffer := make([]byte, 256*1024) 
-	_, err = io.CopyBuffer(w, reader, buffer)
-	if err != nil {
-		log.Printf("Error streaming video: %v", err)
-	}
+	serveRanges(w, r, obj, contentType, func(start, end int64) (io.ReadCloser, error) {
+		return h.storage.GetVideo(ctx, videoName, start, end)
+	})
 }
 
-func parseRangeHeader(r *http.Request, fileSize int64) (start, end int64) {
-	rangeHeader := r.Header.Get("Range")
-	if rangeHeader == "" {
-		return 0, fileSize - 1
-	}
-
-	_, err := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end)
-	if err != nil {
-		_, err = fmt.Sscanf(rangeHeader, "bytes=%d-", &start)
-		if err != nil {
-			return 0, fileSize - 1
-		}
-		end = fileSize - 1
-	}
-
-	if start < 0 {
-		start = 0
-	}
-	if end >= fileSize {
-		end = fileSize - 1
+// streamCacheControl returns the caching policy for streaming output. A
+// reprocessed video rewrites its manifests and segments under the same names,
+// so nothing is cached for good: manifests are kept short so the new ladder is
+// picked up quickly, and segments are revalidated against their ETag once
+// their max-age runs out.
+func streamCacheControl(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".m3u8", ".mpd":
+		return "public, max-age=60"
+	case ".ts", ".m4s":
+		return "public, max-age=600"
+	default:
+		return ""
 	}
-	return start, end
 }
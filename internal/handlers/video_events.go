@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dayquest/cdn/internal/database"
+	"github.com/gorilla/mux"
+)
+
+// eventHeartbeatInterval is how often an idle event stream is kept alive and
+// resynced against the database, in case a notification was missed
+const eventHeartbeatInterval = 15 * time.Second
+
+// StreamVideoEvents pushes status transitions of a video as Server-Sent
+// Events. The current state is sent first; the stream ends after the video
+// has completed or failed.
+func (h *VideoHandler) StreamVideoEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	videoID := strings.TrimSuffix(mux.Vars(r)["video"], ".mp4")
+
+	// Subscribe before reading the current state so nothing falls in between
+	events, unsubscribe := h.events.Subscribe(videoID)
+	defer unsubscribe()
+
+	current, err := h.currentVideoEvent(videoID)
+	if err != nil {
+		log.Printf("Error checking video status: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "not_found",
+			"message": "Video not found",
+		})
+		return
+	}
+
+	// Event streams outlive the server's write timeout by design
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Error clearing write deadline for event stream: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if err := writeVideoEvent(w, rc, current); err != nil || current.Final() {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			if err := writeVideoEvent(w, rc, event); err != nil || event.Final() {
+				return
+			}
+		case <-heartbeat.C:
+			if current, err := h.currentVideoEvent(videoID); err == nil && current.Final() {
+				writeVideoEvent(w, rc, current)
+				return
+			}
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// currentVideoEvent builds an event describing the video's state as stored
+func (h *VideoHandler) currentVideoEvent(videoID string) (database.VideoEvent, error) {
+	status, err := h.db.GetVideoStatus(videoID)
+	if err != nil {
+		return database.VideoEvent{}, err
+	}
+	if status == database.StatusUnknown {
+		return database.VideoEvent{}, fmt.Errorf("video has unknown status: %s", videoID)
+	}
+
+	event := database.VideoEvent{Key: videoID, Status: status.String()}
+	switch status {
+	case database.StatusProcessing:
+		if progress, err := h.db.GetJobProgress(videoID); err == nil && progress != nil {
+			event.Stage = progress.Stage
+			event.Percent = math.Round(progress.Percent*10) / 10
+			event.ETASeconds = int(progress.ETA / time.Second)
+		}
+	case database.StatusCompleted:
+		event.CDNURL = fmt.Sprintf("/video/%s", videoID)
+	case database.StatusFailed:
+		if progress, err := h.db.GetJobProgress(videoID); err == nil && progress != nil {
+			event.Reason = progress.LastError
+		}
+	}
+
+	return event, nil
+}
+
+func writeVideoEvent(w http.ResponseWriter, rc *http.ResponseController, event database.VideoEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Status, payload); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dayquest/cdn/internal/database"
+	"github.com/dayquest/cdn/internal/webhooks"
+	"github.com/gorilla/mux"
+)
+
+// WebhookHandler exposes the webhook delivery log
+type WebhookHandler struct {
+	db         *database.DBHandler
+	dispatcher *webhooks.Dispatcher
+}
+
+// NewWebhookHandler creates a new WebhookHandler
+func NewWebhookHandler(db *database.DBHandler, dispatcher *webhooks.Dispatcher) *WebhookHandler {
+	return &WebhookHandler{
+		db:         db,
+		dispatcher: dispatcher,
+	}
+}
+
+// ListDeliveries returns recent deliveries, filtered by ?state= if given
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+	}
+
+	deliveries, err := h.db.ListWebhookDeliveries(r.URL.Query().Get("state"), limit)
+	if err != nil {
+		log.Printf("Error listing webhook deliveries: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "error",
+			"message": "Error listing webhook deliveries",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"data":   deliveries,
+	})
+}
+
+// ReplayDelivery queues a delivery to be sent again
+func (h *WebhookHandler) ReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "error",
+			"message": "Invalid delivery id",
+		})
+		return
+	}
+
+	found, err := h.db.ReplayWebhookDelivery(id)
+	if err != nil {
+		log.Printf("Error replaying webhook delivery %d: %v", id, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "error",
+			"message": "Error replaying webhook delivery",
+		})
+		return
+	}
+	if !found {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "not_found",
+			"message": "Webhook delivery not found",
+		})
+		return
+	}
+
+	h.dispatcher.Wake()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "queued",
+		"message": "Webhook delivery queued for replay",
+	})
+}
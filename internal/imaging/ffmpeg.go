@@ -0,0 +1,148 @@
+package imaging
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// encoders records which ffmpeg image encoders this host has, probed once
+var encoders struct {
+	once sync.Once
+	webp bool
+	avif string
+}
+
+func detectEncoders() {
+	encoders.once.Do(func() {
+		out, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+		if err != nil {
+			log.Printf("Could not list ffmpeg encoders, serving JPEG/PNG only: %v", err)
+			return
+		}
+
+		available := make(map[string]bool)
+		for _, line := range strings.Split(string(out), "\n") {
+			if fields := strings.Fields(line); len(fields) >= 2 {
+				available[fields[1]] = true
+			}
+		}
+
+		encoders.webp = available["libwebp"]
+		for _, name := range []string{"libaom-av1", "libsvtav1"} {
+			if available[name] {
+				encoders.avif = name
+				break
+			}
+		}
+		log.Printf("Image encoders: webp=%t avif=%q", encoders.webp, encoders.avif)
+	})
+}
+
+// Negotiate picks the best format for an Accept header, falling back to the
+// format of the original. AVIF is only offered for opaque originals.
+func Negotiate(accept, original string) string {
+	fallback := FormatFor(original)
+
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if acceptQuality(params[1:]) > 0 {
+			accepted[mediaType] = true
+		}
+	}
+	if !accepted["image/avif"] && !accepted["image/webp"] {
+		return fallback
+	}
+
+	detectEncoders()
+	if accepted["image/avif"] && encoders.avif != "" && fallback == FormatJPEG {
+		return FormatAVIF
+	}
+	if accepted["image/webp"] && encoders.webp {
+		return FormatWebP
+	}
+	return fallback
+}
+
+// acceptQuality returns the q parameter of an Accept entry, 1 if absent
+func acceptQuality(params []string) float64 {
+	for _, param := range params {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(name, "q") {
+			q, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return 0
+			}
+			return q
+		}
+	}
+	return 1
+}
+
+// encodeFFmpeg hands img to ffmpeg as PNG and reads back the encoded result.
+// Temp files are used as the AVIF muxer needs a seekable output.
+func encodeFFmpeg(ctx context.Context, w io.Writer, img image.Image, format string, quality int) error {
+	detectEncoders()
+
+	var codecArgs []string
+	switch {
+	case format == FormatWebP && encoders.webp:
+		codecArgs = []string{"-c:v", "libwebp", "-quality", fmt.Sprint(quality)}
+	case format == FormatAVIF && encoders.avif == "libaom-av1":
+		codecArgs = []string{"-c:v", "libaom-av1", "-still-picture", "1", "-cpu-used", "6", "-b:v", "0", "-crf", fmt.Sprint(avifCRF(quality))}
+	case format == FormatAVIF && encoders.avif == "libsvtav1":
+		codecArgs = []string{"-c:v", "libsvtav1", "-preset", "8", "-crf", fmt.Sprint(avifCRF(quality))}
+	default:
+		return fmt.Errorf("no encoder available for %s", format)
+	}
+
+	dir, err := os.MkdirTemp("", "image-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input.png")
+	output := filepath.Join(dir, "output."+format)
+
+	file, err := os.Create(input)
+	if err != nil {
+		return fmt.Errorf("failed to create temp image: %w", err)
+	}
+	err = png.Encode(file, img)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write temp image: %w", err)
+	}
+
+	args := append([]string{"-y", "-hide_banner", "-loglevel", "error", "-i", input}, codecArgs...)
+	args = append(args, "-frames:v", "1", output)
+	if out, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg %s encode failed: %w: %s", format, err, strings.TrimSpace(string(out)))
+	}
+
+	encoded, err := os.Open(output)
+	if err != nil {
+		return fmt.Errorf("failed to open encoded image: %w", err)
+	}
+	defer encoded.Close()
+
+	_, err = io.Copy(w, encoded)
+	return err
+}
+
+// avifCRF maps a 1-100 quality onto the AV1 encoders' 0-63 CRF scale
+func avifCRF(quality int) int {
+	return min(63, 10+(100-quality)*6/10)
+}
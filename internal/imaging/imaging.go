@@ -0,0 +1,243 @@
+// Package imaging resizes and re-encodes stored images for the CDN handlers
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Fit controls how an image is made to fit a requested box
+type Fit string
+
+const (
+	// FitCover fills the box, cropping whatever overflows
+	FitCover Fit = "cover"
+	// FitContain scales the image to lie within the box
+	FitContain Fit = "contain"
+)
+
+// Output formats. WebP and AVIF are encoded through ffmpeg when the host's
+// build has an encoder for them.
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+	FormatAVIF = "avif"
+)
+
+// Sizes are the widths and heights that may be requested. Anything else is
+// rejected so callers cannot fill the variant cache with arbitrary sizes.
+var Sizes = []int{16, 24, 32, 48, 64, 96, 128, 160, 192, 256, 320, 480, 640, 960, 1280, 1920}
+
+// DefaultQuality is the JPEG quality used when none is requested
+const DefaultQuality = 80
+
+// Requested qualities are snapped to a multiple of qualityStep within
+// [minQuality, maxQuality]
+const (
+	minQuality  = 40
+	maxQuality  = 90
+	qualityStep = 10
+)
+
+// maxPixels bounds the originals that will be decoded
+const maxPixels = 50_000_000
+
+// Options describes a requested variant. The zero value means the original.
+type Options struct {
+	Width   int
+	Height  int
+	Fit     Fit
+	Quality int
+}
+
+// IsZero reports whether no transformation was requested
+func (o Options) IsZero() bool {
+	return o.Width == 0 && o.Height == 0
+}
+
+// Key identifies the variant, for use in cache keys
+func (o Options) Key() string {
+	if o.IsZero() {
+		return "original"
+	}
+	return fmt.Sprintf("w%d-h%d-%s-q%d", o.Width, o.Height, o.Fit, o.Quality)
+}
+
+// ParseOptions reads ?w=, ?h=, ?fit= and ?q= from a query string
+func ParseOptions(query url.Values) (Options, error) {
+	var opts Options
+	var err error
+
+	if opts.Width, err = parseSize(query.Get("w")); err != nil {
+		return Options{}, fmt.Errorf("invalid w: %w", err)
+	}
+	if opts.Height, err = parseSize(query.Get("h")); err != nil {
+		return Options{}, fmt.Errorf("invalid h: %w", err)
+	}
+	if opts.IsZero() {
+		return Options{}, nil
+	}
+
+	switch fit := Fit(query.Get("fit")); fit {
+	case "":
+		opts.Fit = FitCover
+	case FitCover, FitContain:
+		opts.Fit = fit
+	default:
+		return Options{}, fmt.Errorf("invalid fit: must be cover or contain")
+	}
+	// With a single dimension there is nothing to crop
+	if opts.Width == 0 || opts.Height == 0 {
+		opts.Fit = FitContain
+	}
+
+	opts.Quality = DefaultQuality
+	if value := query.Get("q"); value != "" {
+		q, err := strconv.Atoi(value)
+		if err != nil || q < 1 || q > 100 {
+			return Options{}, fmt.Errorf("invalid q: must be between 1 and 100")
+		}
+		q = int(math.Round(float64(q)/qualityStep)) * qualityStep
+		opts.Quality = min(max(q, minQuality), maxQuality)
+	}
+
+	return opts, nil
+}
+
+func parseSize(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("not a number")
+	}
+	if i := sort.SearchInts(Sizes, n); i == len(Sizes) || Sizes[i] != n {
+		return 0, fmt.Errorf("must be one of %s", sizeList())
+	}
+	return n, nil
+}
+
+func sizeList() string {
+	sizes := make([]string, len(Sizes))
+	for i, size := range Sizes {
+		sizes[i] = strconv.Itoa(size)
+	}
+	return strings.Join(sizes, ", ")
+}
+
+// FormatFor picks the output format for a stored original. PNGs stay PNG so
+// transparency survives; everything else becomes JPEG.
+func FormatFor(key string) string {
+	if strings.EqualFold(path.Ext(key), ".png") {
+		return FormatPNG
+	}
+	return FormatJPEG
+}
+
+// ContentType returns the MIME type of an output format
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// Extension returns the file extension for an output format
+func Extension(format string) string {
+	if format == FormatJPEG {
+		return ".jpg"
+	}
+	return "." + format
+}
+
+// Decode reads an image, refusing ones too large to decode safely
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image header: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, fmt.Errorf("image of %dx%d is too large", cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// Resize scales src according to opts. Images are never upscaled; a cover
+// crop of a small original keeps the requested aspect ratio at its own size.
+func Resize(src image.Image, opts Options) image.Image {
+	bounds := src.Bounds()
+	sw, sh := float64(bounds.Dx()), float64(bounds.Dy())
+	crop := bounds
+
+	var scale float64
+	switch {
+	case opts.Width == 0:
+		scale = float64(opts.Height) / sh
+	case opts.Height == 0:
+		scale = float64(opts.Width) / sw
+	case opts.Fit == FitContain:
+		scale = math.Min(float64(opts.Width)/sw, float64(opts.Height)/sh)
+	default:
+		scale = math.Max(float64(opts.Width)/sw, float64(opts.Height)/sh)
+
+		// Crop the source to the box's aspect ratio around its centre
+		cw := int(math.Round(float64(opts.Width) / scale))
+		ch := int(math.Round(float64(opts.Height) / scale))
+		x := bounds.Min.X + (bounds.Dx()-cw)/2
+		y := bounds.Min.Y + (bounds.Dy()-ch)/2
+		crop = image.Rect(x, y, x+cw, y+ch).Intersect(bounds)
+	}
+
+	scale = math.Min(scale, 1)
+	w := max(1, int(math.Round(float64(crop.Dx())*scale)))
+	h := max(1, int(math.Round(float64(crop.Dy())*scale)))
+
+	if w == bounds.Dx() && h == bounds.Dy() {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+	return dst
+}
+
+// Encode writes img in the given format
+func Encode(ctx context.Context, w io.Writer, img image.Image, format string, quality int) error {
+	if quality == 0 {
+		quality = DefaultQuality
+	}
+
+	switch format {
+	case FormatPNG:
+		return png.Encode(w, img)
+	case FormatJPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case FormatWebP, FormatAVIF:
+		return encodeFFmpeg(ctx, w, img, format, quality)
+	default:
+		return fmt.Errorf("unsupported image format %q", format)
+	}
+}
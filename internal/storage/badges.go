@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dayquest/cdn/internal/database"
+	"github.com/minio/minio-go/v7"
+)
+
+func (s *MinioStorage) GetBadgeImage(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	return s.client.GetObject(ctx, s.badgesBucket, objectName, minio.GetObjectOptions{})
+}
+
+func (s *MinioStorage) StatBadgeImage(ctx context.Context, objectName string) (ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.badgesBucket, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to stat badge image: %w", err)
+	}
+	return objectInfo(info), nil
+}
+
+func (s *MinioStorage) UploadBadgeImage(ctx context.Context, objectName string, reader io.Reader, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.badgesBucket, objectName, reader, -1, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading badge image %s: %w", objectName, err)
+	}
+	return nil
+}
+
+func (s *MinioStorage) DeleteBadgeImage(ctx context.Context, objectName string) error {
+	return s.DeleteObject(ctx, s.badgesBucket, objectName)
+}
+
+// PurgeBadgeVariants drops every cached variant of a badge image
+func (s *MinioStorage) PurgeBadgeVariants(ctx context.Context, objectName string) error {
+	return s.removePrefix(ctx, s.badgesBucket, variantsPrefix+objectName+"/")
+}
+
+// BadgeETag derives the ETag a badge image is served with from its content
+func BadgeETag(image []byte) string {
+	sum := sha256.Sum256(image)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// badgeSeed is an entry of the manifest.json in the seed directory
+type badgeSeed struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	Rarity      string `json:"rarity"`
+}
+
+// SeedBadges imports the badges listed in dir/manifest.json into an empty
+// catalog, so a fresh deployment starts with the badges that used to ship
+// inside the image
+func (s *MinioStorage) SeedBadges(ctx context.Context, db *database.DBHandler, dir string) error {
+	count, err := db.CountBadges()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("No badge manifest in %s, starting with an empty catalog", dir)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read badge manifest: %w", err)
+	}
+
+	var seeds []badgeSeed
+	if err := json.Unmarshal(data, &seeds); err != nil {
+		return fmt.Errorf("failed to parse badge manifest: %w", err)
+	}
+
+	for _, seed := range seeds {
+		image, err := os.ReadFile(filepath.Join(dir, seed.Image))
+		if err != nil {
+			return fmt.Errorf("failed to read image of badge %s: %w", seed.ID, err)
+		}
+
+		// Stored images are named after the badge id rather than the seed
+		// file, which also leaves misspelled file names behind
+		ext := strings.ToLower(filepath.Ext(seed.Image))
+		imageKey := seed.ID + ext
+		contentType := "image/jpeg"
+		if ext == ".png" {
+			contentType = "image/png"
+		}
+
+		if err := s.UploadBadgeImage(ctx, imageKey, bytes.NewReader(image), contentType); err != nil {
+			return err
+		}
+
+		created, err := db.CreateBadge(database.Badge{
+			ID:          seed.ID,
+			Title:       seed.Title,
+			Description: seed.Description,
+			Rarity:      seed.Rarity,
+			ImageKey:    imageKey,
+			ETag:        BadgeETag(image),
+		})
+		if err != nil {
+			return err
+		}
+		if created {
+			log.Printf("Seeded badge %s from %s", seed.ID, seed.Image)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ThumbnailCandidates is how many poster frames are offered per video on top
+// of the automatic pick, which is always stored as candidate 0
+const ThumbnailCandidates = 5
+
+// ThumbnailCandidateKey returns the thumbnail bucket key of candidate n for a
+// video
+func ThumbnailCandidateKey(videoKey string, n int) string {
+	return fmt.Sprintf("%s/candidate-%d.jpg", StreamPrefix(videoKey), n)
+}
+
+// createThumbnailCandidates writes poster frame candidates into a new temp
+// dir. Frames come from scene changes between keyframes; if the video has too
+// few cuts the rest are spread evenly over its duration.
+func (vp *VideoProcessor) createThumbnailCandidates(ctx context.Context, videoPath, thumbnailPath string, duration time.Duration) (string, error) {
+	dir, err := os.MkdirTemp("", "candidates-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create candidates dir: %w", err)
+	}
+
+	auto, err := os.ReadFile(thumbnailPath)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to read thumbnail: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "candidate-0.jpg"), auto, 0o644); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to write thumbnail candidate: %w", err)
+	}
+
+	sceneArgs := []string{
+		"ffmpeg", "-y",
+		"-skip_frame", "nokey", "-i", videoPath,
+		"-vf", "select='gt(scene,0.3)'",
+		"-fps_mode", "vfr",
+		"-frames:v", fmt.Sprint(ThumbnailCandidates),
+		filepath.Join(dir, "candidate-%d.jpg"),
+	}
+	if _, err := runMediaCommand(ctx, sceneArgs); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to extract scene candidates: %w", err)
+	}
+
+	for n := 1; n <= ThumbnailCandidates; n++ {
+		path := filepath.Join(dir, fmt.Sprintf("candidate-%d.jpg", n))
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+
+		at := duration * time.Duration(n) / (ThumbnailCandidates + 1)
+		frameArgs := []string{
+			"ffmpeg", "-y",
+			"-ss", fmt.Sprintf("%.3f", at.Seconds()), "-i", videoPath,
+			"-frames:v", "1",
+			path,
+		}
+		if _, err := runMediaCommand(ctx, frameArgs); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to extract thumbnail candidate %d: %w", n, err)
+		}
+	}
+
+	return dir, nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxStderrTail is how much of a command's stderr is kept for error messages
+const maxStderrTail = 4096
+
+// tailBuffer keeps only the last maxStderrTail bytes written to it, so chatty
+// ffmpeg runs do not pile up in memory
+type tailBuffer struct {
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > maxStderrTail {
+		t.buf = t.buf[len(t.buf)-maxStderrTail:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.buf)
+}
+
+// runMediaCommand runs an ffmpeg or ffprobe invocation and returns its stdout.
+// The process is killed when ctx is done. Failures are classified as
+// retryable or permanent by classifyCommandError.
+func runMediaCommand(ctx context.Context, cmdArgs []string) ([]byte, error) {
+	var stdout bytes.Buffer
+	var stderr tailBuffer
+
+	cmd := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s interrupted: %w", cmdArgs[0], ctx.Err())
+		}
+		return nil, classifyCommandError(cmdArgs[0], err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// runFFmpegWithProgress runs an ffmpeg invocation with machine-readable
+// progress on stdout and reports the fraction of duration encoded so far. The
+// process is killed when ctx is done.
+func runFFmpegWithProgress(ctx context.Context, cmdArgs []string, duration time.Duration, report func(fraction float64)) error {
+	args := append([]string{cmdArgs[0], "-progress", "pipe:1", "-nostats"}, cmdArgs[1:]...)
+
+	var stderr tailBuffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return classifyCommandError(args[0], err, "")
+	}
+	if err := cmd.Start(); err != nil {
+		return classifyCommandError(args[0], err, "")
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us":
+			us, err := strconv.ParseInt(value, 10, 64)
+			if err == nil && duration > 0 {
+				report(float64(us) / float64(duration.Microseconds()))
+			}
+		case "progress":
+			if value == "end" {
+				report(1)
+			}
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s interrupted: %w", args[0], ctx.Err())
+		}
+		return classifyCommandError(args[0], err, stderr.String())
+	}
+
+	return nil
+}
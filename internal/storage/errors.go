@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// permanentError marks a processing failure that will not go away on retry,
+// such as corrupt or unsupported input
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that IsPermanent reports true for it
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was classified as not worth retrying.
+// Anything not explicitly marked permanent is assumed to be transient.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// transientCommandOutput lists stderr fragments that point at the environment
+// rather than the input
+var transientCommandOutput = []string{
+	"No space left on device",
+	"Cannot allocate memory",
+	"Resource temporarily unavailable",
+}
+
+// classifyCommandError turns a failed ffmpeg/ffprobe run into an error that
+// carries the tail of stderr. A process killed by a signal (typically the OOM
+// killer) or one that ran out of resources is retryable; any other non-zero
+// exit means the tool rejected the input and is permanent.
+func classifyCommandError(name string, err error, stderr string) error {
+	detail := lastLine(stderr)
+	wrapped := fmt.Errorf("%s command fail: %w", name, err)
+	if detail != "" {
+		wrapped = fmt.Errorf("%s command fail: %w: %s", name, err, detail)
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return wrapped
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return wrapped
+	}
+	for _, fragment := range transientCommandOutput {
+		if strings.Contains(stderr, fragment) {
+			return wrapped
+		}
+	}
+
+	return Permanent(wrapped)
+}
+
+// isMissingObject reports whether err is MinIO's answer for a key that does
+// not exist
+func isMissingObject(err error) bool {
+	var resp minio.ErrorResponse
+	return errors.As(err, &resp) && resp.Code == "NoSuchKey"
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/dayquest/cdn/internal/config"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/notification"
 )
 
 type MinioStorage struct {
@@ -20,6 +21,7 @@ type MinioStorage struct {
 	failedBucket       string
 	thumbnailBucket    string
 	profileImageBucket string
+	badgesBucket       string
 }
 
 func NewMinioStorage(cfg *config.Config) (*MinioStorage, error) {
@@ -38,6 +40,7 @@ func NewMinioStorage(cfg *config.Config) (*MinioStorage, error) {
 		failedBucket:       cfg.FailedBucket,
 		thumbnailBucket:    cfg.ThumbnailBucket,
 		profileImageBucket: cfg.ProfileImageBucket,
+		badgesBucket:       cfg.BadgesBucket,
 	}
 
 	// Ensure the buckets exist
@@ -48,6 +51,7 @@ func NewMinioStorage(cfg *config.Config) (*MinioStorage, error) {
 		storage.failedBucket,
 		storage.thumbnailBucket,
 		storage.profileImageBucket,
+		storage.badgesBucket,
 	}
 
 	for _, bucket := range buckets {
@@ -113,6 +117,14 @@ func (s *MinioStorage) ListObjects(ctx context.Context, bucketName string) ([]mi
 	return objects, nil
 }
 
+// ListenRawUploads subscribes to object-created notifications on the raw videos
+// bucket. The channel is closed when ctx is done or the connection is lost.
+func (s *MinioStorage) ListenRawUploads(ctx context.Context) <-chan notification.Info {
+	return s.client.ListenBucketNotification(ctx, s.rawVideosBucket, "", "", []string{
+		"s3:ObjectCreated:*",
+	})
+}
+
 func (s *MinioStorage) GetObject(ctx context.Context, objectName string, start, end int64) (io.ReadCloser, error) {
 	opts := minio.GetObjectOptions{}
 	if start > 0 || end >= 0 {
@@ -223,12 +235,23 @@ func (s *MinioStorage) GetHLSContent(ctx context.Context, objectName string) (io
 	return s.client.GetObject(ctx, s.videosBucket, objectName, opts)
 }
 
+// objectInfo keeps the parts of a MinIO stat result that handlers need to
+// serve and validate an object
+func objectInfo(info minio.ObjectInfo) ObjectInfo {
+	return ObjectInfo{
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+	}
+}
+
 func (s *MinioStorage) StatObject(ctx context.Context, objectName string) (ObjectInfo, error) {
 	info, err := s.client.StatObject(ctx, s.rawVideosBucket, objectName, minio.StatObjectOptions{})
 	if err != nil {
 		return ObjectInfo{}, fmt.Errorf("failed to stat object: %w", err)
 	}
-	return ObjectInfo{Size: info.Size, ContentType: info.ContentType}, nil
+	return objectInfo(info), nil
 }
 
 func (s *MinioStorage) StatThumbnail(ctx context.Context, objectName string) (ObjectInfo, error) {
@@ -236,7 +259,7 @@ func (s *MinioStorage) StatThumbnail(ctx context.Context, objectName string) (Ob
 	if err != nil {
 		return ObjectInfo{}, fmt.Errorf("failed to stat thumbnail: %w", err)
 	}
-	return ObjectInfo{Size: info.Size, ContentType: info.ContentType}, nil
+	return objectInfo(info), nil
 }
 
 func (s *MinioStorage) StatVideo(ctx context.Context, objectName string) (ObjectInfo, error) {
@@ -244,7 +267,7 @@ func (s *MinioStorage) StatVideo(ctx context.Context, objectName string) (Object
 	if err != nil {
 		return ObjectInfo{}, fmt.Errorf("failed to stat video: %w", err)
 	}
-	return ObjectInfo{Size: info.Size, ContentType: info.ContentType}, nil
+	return objectInfo(info), nil
 }
 
 func (s *MinioStorage) StatProfileImage(ctx context.Context, imagePath string) (ObjectInfo, error) {
@@ -252,7 +275,7 @@ func (s *MinioStorage) StatProfileImage(ctx context.Context, imagePath string) (
 	if err != nil {
 		return ObjectInfo{}, fmt.Errorf("failed to stat profile image: %w", err)
 	}
-	return ObjectInfo{Size: info.Size, ContentType: info.ContentType}, nil
+	return objectInfo(info), nil
 }
 
 // PutObject für Kompatibilität mit dem VideoProcessor
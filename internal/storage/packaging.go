@@ -0,0 +1,354 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// HLSMasterPlaylist is the name of the master playlist written under a video's stream prefix
+const HLSMasterPlaylist = "master.m3u8"
+
+// DASHManifest is the name of the MPEG-DASH manifest written under a video's stream prefix
+const DASHManifest = "manifest.mpd"
+
+// streamSegmentSeconds is the target segment duration; keyframes are forced on this boundary
+const streamSegmentSeconds = 4
+
+// Packaging modes accepted by the PACKAGING_MODE setting
+const (
+	// PackagingHLS writes MPEG-TS segments with HLS playlists only
+	PackagingHLS = "hls"
+	// PackagingDASH writes fMP4 segments with a DASH manifest only
+	PackagingDASH = "dash"
+	// PackagingCMAF writes one set of fMP4 segments referenced by both HLS and DASH manifests
+	PackagingCMAF = "cmaf"
+)
+
+// Rendition describes one variant of the adaptive-bitrate ladder
+type Rendition struct {
+	Name         string
+	Height       int
+	VideoBitrate string
+	MaxRate      string
+	BufSize      string
+	AudioBitrate string
+}
+
+// defaultRenditions is the ladder every upload is encoded to, capped at the source resolution
+var defaultRenditions = []Rendition{
+	{Name: "240p", Height: 240, VideoBitrate: "400k", MaxRate: "428k", BufSize: "600k", AudioBitrate: "64k"},
+	{Name: "480p", Height: 480, VideoBitrate: "1400k", MaxRate: "1498k", BufSize: "2100k", AudioBitrate: "96k"},
+	{Name: "720p", Height: 720, VideoBitrate: "2800k", MaxRate: "2996k", BufSize: "4200k", AudioBitrate: "128k"},
+	{Name: "1080p", Height: 1080, VideoBitrate: "5000k", MaxRate: "5350k", BufSize: "7500k", AudioBitrate: "128k"},
+}
+
+// StreamPrefix returns the object prefix that holds the streaming output of a video
+func StreamPrefix(videoKey string) string {
+	return strings.TrimSuffix(filepath.Base(videoKey), filepath.Ext(videoKey))
+}
+
+// renditionsFor returns the ladder entries that do not upscale a source whose
+// short side is sourceHeight. Sources smaller than the lowest rung still get
+// that single rung so every video has at least one variant.
+func renditionsFor(sourceHeight int) []Rendition {
+	var ladder []Rendition
+	for _, r := range defaultRenditions {
+		if r.Height <= sourceHeight {
+			ladder = append(ladder, r)
+		}
+	}
+	if len(ladder) == 0 {
+		ladder = append(ladder, defaultRenditions[0])
+	}
+	return ladder
+}
+
+// packageStreams encodes inputPath into the rendition ladder and writes the
+// manifests and segments for the configured packaging mode into outputDir
+func (vp *VideoProcessor) packageStreams(ctx context.Context, inputPath, outputDir string, source sourceInfo, report func(fraction float64)) error {
+	ladder := renditionsFor(source.ShortSide())
+
+	var cmdArgs []string
+	switch vp.packaging {
+	case PackagingDASH, PackagingCMAF:
+		cmdArgs = dashArgs(inputPath, outputDir, ladder, source, vp.packaging == PackagingCMAF)
+	default:
+		cmdArgs = hlsArgs(inputPath, outputDir, ladder, source)
+	}
+
+	if err := runFFmpegWithProgress(ctx, cmdArgs, source.Duration, report); err != nil {
+		return fmt.Errorf("%s packaging: %w", vp.packaging, err)
+	}
+
+	return nil
+}
+
+// remuxProgressive rebuilds a single faststart MP4 from the top rung of the
+// packaged ladder without encoding again, so the progressive download costs a
+// copy rather than a second transcode
+func (vp *VideoProcessor) remuxProgressive(ctx context.Context, streamDir string, source sourceInfo) (string, error) {
+	ladder := renditionsFor(source.ShortSide())
+	top := len(ladder) - 1
+	outputPath := fmt.Sprintf("%s-progressive.mp4", streamDir)
+
+	var inputs []string
+	switch vp.packaging {
+	case PackagingDASH, PackagingCMAF:
+		// Representations are numbered in map order: video rungs, then audio
+		video, err := joinFragments(streamDir, top)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(video)
+		inputs = append(inputs, "-i", video)
+
+		if source.HasAudio {
+			audio, err := joinFragments(streamDir, len(ladder))
+			if err != nil {
+				return "", err
+			}
+			defer os.Remove(audio)
+			inputs = append(inputs, "-i", audio, "-map", "0:v:0", "-map", "1:a:0")
+		}
+	default:
+		inputs = append(inputs, "-i", filepath.Join(streamDir, ladder[top].Name, "index.m3u8"))
+	}
+
+	cmdArgs := append([]string{"ffmpeg", "-y"}, inputs...)
+	cmdArgs = append(cmdArgs, "-c", "copy")
+	if source.HasAudio && vp.packaging == PackagingHLS {
+		cmdArgs = append(cmdArgs, "-bsf:a", "aac_adtstoasc")
+	}
+	cmdArgs = append(cmdArgs, "-f", "mp4", "-movflags", "+faststart", outputPath)
+
+	if _, err := runMediaCommand(ctx, cmdArgs); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("failed to remux progressive mp4: %w", err)
+	}
+	return outputPath, nil
+}
+
+// joinFragments concatenates the init segment and media segments of one DASH
+// representation into a fragmented MP4 that ffmpeg can read as a whole
+func joinFragments(streamDir string, representation int) (string, error) {
+	chunks, err := filepath.Glob(filepath.Join(streamDir, fmt.Sprintf("chunk-%d-*.m4s", representation)))
+	if err != nil {
+		return "", err
+	}
+	if len(chunks) == 0 {
+		return "", fmt.Errorf("no segments for representation %d", representation)
+	}
+	// Segment numbers are zero-padded, so the glob order is playback order
+	sort.Strings(chunks)
+
+	out, err := os.CreateTemp("", fmt.Sprintf("representation-%d-*.mp4", representation))
+	if err != nil {
+		return "", fmt.Errorf("failed to create fragment file: %w", err)
+	}
+	defer out.Close()
+
+	parts := append([]string{filepath.Join(streamDir, fmt.Sprintf("init-%d.m4s", representation))}, chunks...)
+	for _, part := range parts {
+		if err := appendFile(out, part); err != nil {
+			os.Remove(out.Name())
+			return "", err
+		}
+	}
+	return out.Name(), nil
+}
+
+func appendFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filepath.Base(path), err)
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// ladderEncodeArgs returns the input, filter graph, video mapping and x264
+// settings shared by every packaging mode
+func ladderEncodeArgs(inputPath string, ladder []Rendition) []string {
+	cmdArgs := []string{"ffmpeg", "-y", "-i", inputPath, "-filter_complex", ladderFilterGraph(ladder)}
+	for i := range ladder {
+		cmdArgs = append(cmdArgs, "-map", fmt.Sprintf("[v%dout]", i))
+	}
+
+	cmdArgs = append(cmdArgs,
+		"-c:v", "libx264",
+		"-preset", "fast",
+		"-profile:v", "main",
+		"-sc_threshold", "0",
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", streamSegmentSeconds),
+	)
+	for i, r := range ladder {
+		cmdArgs = append(cmdArgs,
+			fmt.Sprintf("-b:v:%d", i), r.VideoBitrate,
+			fmt.Sprintf("-maxrate:v:%d", i), r.MaxRate,
+			fmt.Sprintf("-bufsize:v:%d", i), r.BufSize,
+		)
+	}
+	return cmdArgs
+}
+
+// hlsArgs muxes one audio track into every variant and writes MPEG-TS segments
+func hlsArgs(inputPath, outputDir string, ladder []Rendition, source sourceInfo) []string {
+	cmdArgs := ladderEncodeArgs(inputPath, ladder)
+
+	var streamMap []string
+	for i, r := range ladder {
+		entry := fmt.Sprintf("v:%d", i)
+		if source.HasAudio {
+			cmdArgs = append(cmdArgs, "-map", "0:a:0", fmt.Sprintf("-b:a:%d", i), r.AudioBitrate)
+			entry += fmt.Sprintf(",a:%d", i)
+		}
+		streamMap = append(streamMap, entry+",name:"+r.Name)
+	}
+	if source.HasAudio {
+		cmdArgs = append(cmdArgs, "-c:a", "aac", "-ar", "44100")
+	}
+
+	return append(cmdArgs,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(streamSegmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_flags", "independent_segments",
+		"-hls_segment_filename", filepath.Join(outputDir, "%v", "segment_%03d.ts"),
+		"-master_pl_name", HLSMasterPlaylist,
+		"-var_stream_map", strings.Join(streamMap, " "),
+		filepath.Join(outputDir, "%v", "index.m3u8"),
+	)
+}
+
+// dashArgs writes fMP4 (CMAF) segments with a single shared audio
+// representation. With withHLS set the dash muxer also emits HLS playlists
+// that reference the very same segments, so storage is not doubled.
+func dashArgs(inputPath, outputDir string, ladder []Rendition, source sourceInfo, withHLS bool) []string {
+	cmdArgs := ladderEncodeArgs(inputPath, ladder)
+
+	adaptationSets := "id=0,streams=v"
+	if source.HasAudio {
+		cmdArgs = append(cmdArgs,
+			"-map", "0:a:0",
+			"-c:a", "aac",
+			"-ar", "44100",
+			"-b:a", ladder[len(ladder)-1].AudioBitrate,
+		)
+		adaptationSets += " id=1,streams=a"
+	}
+
+	cmdArgs = append(cmdArgs,
+		"-f", "dash",
+		"-seg_duration", strconv.Itoa(streamSegmentSeconds),
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		"-adaptation_sets", adaptationSets,
+	)
+	if withHLS {
+		cmdArgs = append(cmdArgs, "-hls_playlist", "1", "-hls_master_name", HLSMasterPlaylist)
+	}
+
+	return append(cmdArgs, filepath.Join(outputDir, DASHManifest))
+}
+
+// ladderFilterGraph splits the input video once per rendition and scales each
+// branch so that its short side matches the rendition height. Every branch is
+// converted to 8-bit 4:2:0, the only format the main profile allows, so 10-bit
+// HDR phone footage and 4:2:2/4:4:4 sources encode instead of being rejected.
+func ladderFilterGraph(ladder []Rendition) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[0:v]split=%d", len(ladder))
+	for i := range ladder {
+		fmt.Fprintf(&b, "[v%d]", i)
+	}
+	for i, r := range ladder {
+		fmt.Fprintf(&b, ";[v%d]scale=w='if(gt(iw,ih),-2,%d)':h='if(gt(iw,ih),%d,-2)',format=yuv420p[v%dout]", i, r.Height, r.Height, i)
+	}
+	return b.String()
+}
+
+// uploadStreamDir uploads every file below dir to the videos bucket under the
+// stream prefix of videoKey, preserving the relative layout. Output of an
+// earlier run is removed first, so segments of rungs or lengths the new ladder
+// no longer has are not left behind.
+func (vp *VideoProcessor) uploadStreamDir(ctx context.Context, dir, videoKey string, report func(fraction float64)) error {
+	prefix := StreamPrefix(videoKey)
+
+	if err := vp.storage.removePrefix(ctx, vp.storage.videosBucket, prefix+"/"); err != nil {
+		return fmt.Errorf("failed to clear previous stream output: %w", err)
+	}
+
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, path := range files {
+		if err := vp.uploadStreamFile(ctx, dir, path, prefix); err != nil {
+			return err
+		}
+		report(float64(i+1) / float64(len(files)))
+	}
+	return nil
+}
+
+func (vp *VideoProcessor) uploadStreamFile(ctx context.Context, dir, path, prefix string) error {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return err
+	}
+	objectName := prefix + "/" + filepath.ToSlash(rel)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", rel, err)
+	}
+	defer file.Close()
+
+	_, err = vp.storage.PutObject(
+		ctx,
+		vp.storage.videosBucket,
+		objectName,
+		file,
+		-1,
+		minio.PutObjectOptions{ContentType: StreamContentType(objectName)},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", objectName, err)
+	}
+	return nil
+}
+
+// StreamContentType returns the MIME type for a streaming manifest or segment
+func StreamContentType(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/MP2T"
+	case ".mpd":
+		return "application/dash+xml"
+	case ".m4s":
+		return "video/iso.segment"
+	default:
+		return "application/octet-stream"
+	}
+}
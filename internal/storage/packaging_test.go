@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLadderFilterGraphForces420(t *testing.T) {
+	graph := ladderFilterGraph(defaultRenditions)
+
+	branches := strings.Split(graph, ";")[1:]
+	if len(branches) != len(defaultRenditions) {
+		t.Fatalf("graph %q has %d scaled branches, want %d", graph, len(branches), len(defaultRenditions))
+	}
+	for i, branch := range branches {
+		if !strings.Contains(branch, ",format=yuv420p[") {
+			t.Errorf("branch %d %q does not convert to yuv420p before the encoder", i, branch)
+		}
+	}
+}
+
+// TestPackageStreamsHighBitDepth runs the ladder on 10-bit 4:2:2 input, which
+// the main profile cannot encode without the format conversion
+func TestPackageStreamsHighBitDepth(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+
+	ctx := context.Background()
+	input := filepath.Join(t.TempDir(), "hdr.mkv")
+	_, err := runMediaCommand(ctx, []string{
+		"ffmpeg", "-y",
+		"-f", "lavfi", "-i", "testsrc2=size=640x360:rate=25:duration=2",
+		"-pix_fmt", "yuv422p10le",
+		"-c:v", "libx264", "-preset", "ultrafast",
+		input,
+	})
+	if err != nil {
+		t.Skipf("ffmpeg cannot produce 10-bit test input: %v", err)
+	}
+
+	source := sourceInfo{Width: 640, Height: 360, Duration: 2 * time.Second}
+	for _, mode := range []string{PackagingHLS, PackagingDASH, PackagingCMAF} {
+		t.Run(mode, func(t *testing.T) {
+			vp := &VideoProcessor{packaging: mode}
+			outputDir := t.TempDir()
+
+			if err := vp.packageStreams(ctx, input, outputDir, source, func(float64) {}); err != nil {
+				t.Fatalf("packageStreams: %v", err)
+			}
+
+			manifest := HLSMasterPlaylist
+			if mode != PackagingHLS {
+				manifest = DASHManifest
+			}
+			if _, err := os.Stat(filepath.Join(outputDir, manifest)); err != nil {
+				t.Errorf("manifest missing: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dayquest/cdn/internal/database"
+	"github.com/dayquest/cdn/internal/imaging"
+)
+
+// saveThumbnailPlaceholder stores the BlurHash of a freshly extracted
+// thumbnail so clients can show it while the image loads
+func (vp *VideoProcessor) saveThumbnailPlaceholder(thumbnailPath, thumbnailKey string) error {
+	file, err := os.Open(thumbnailPath)
+	if err != nil {
+		return fmt.Errorf("failed to open thumbnail file: %w", err)
+	}
+	defer file.Close()
+
+	img, err := imaging.Decode(file)
+	if err != nil {
+		return err
+	}
+
+	hash, err := imaging.BlurHash(img)
+	if err != nil {
+		return err
+	}
+
+	return vp.db.SavePlaceholder(database.PlaceholderThumbnail, thumbnailKey, database.Placeholder{
+		BlurHash: hash,
+		Width:    img.Bounds().Dx(),
+		Height:   img.Bounds().Dy(),
+	})
+}
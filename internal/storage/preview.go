@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Feed preview loops are stored under a video's prefix in the thumbnail
+// bucket as preview-<content hash>.<ext>. Every version gets its own key, so
+// they can be cached for good; the current keys are kept in the database.
+const previewPrefix = "preview-"
+
+// previewHashLength is the number of hex characters of the content hash kept
+// in a preview's name
+const previewHashLength = 16
+
+const (
+	previewLength    = 3 * time.Second
+	previewShortSide = 360
+)
+
+// previewStart picks the segment the loop is cut from. A third of the way in
+// skips most intros and title cards while staying clear of the ending.
+func previewStart(duration time.Duration) time.Duration {
+	if duration <= previewLength {
+		return 0
+	}
+	start := duration / 3
+	if start+previewLength > duration {
+		start = duration - previewLength
+	}
+	return start
+}
+
+// previewScale returns a scale filter bringing the short side down to
+// previewShortSide without ever upscaling
+func previewScale(source sourceInfo) string {
+	if source.ShortSide() <= previewShortSide {
+		return "scale=trunc(iw/2)*2:trunc(ih/2)*2"
+	}
+	if source.Width >= source.Height {
+		return fmt.Sprintf("scale=-2:%d", previewShortSide)
+	}
+	return fmt.Sprintf("scale=%d:-2", previewShortSide)
+}
+
+// VersionedAsset reports whether a thumbnail bucket asset name carries its
+// content hash, meaning the object under it never changes
+func VersionedAsset(name string) bool {
+	hash, ok := strings.CutPrefix(strings.TrimSuffix(name, filepath.Ext(name)), previewPrefix)
+	if !ok || len(hash) != previewHashLength {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// previewClip is a generated set of preview loops in a temp dir, with the
+// file name of each by format ("mp4", "webp")
+type previewClip struct {
+	dir   string
+	names map[string]string
+}
+
+// createPreview cuts a short muted loop from the source as a low-bitrate MP4
+// and an animated WebP, written into a new temp dir under versioned names.
+// The WebP is skipped when ffmpeg has no libwebp encoder.
+func (vp *VideoProcessor) createPreview(ctx context.Context, videoPath string, source sourceInfo) (*previewClip, error) {
+	dir, err := os.MkdirTemp("", "preview-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create preview dir: %w", err)
+	}
+
+	start := fmt.Sprintf("%.3f", previewStart(source.Duration).Seconds())
+	length := fmt.Sprintf("%.3f", previewLength.Seconds())
+	scale := previewScale(source)
+
+	mp4Args := []string{
+		"ffmpeg", "-y",
+		"-ss", start, "-t", length, "-i", videoPath,
+		"-an",
+		"-vf", "fps=24," + scale,
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "30",
+		"-maxrate", "400k",
+		"-bufsize", "800k",
+		"-pix_fmt", "yuv420p",
+		"-movflags", "+faststart",
+		filepath.Join(dir, "preview.mp4"),
+	}
+	if _, err := runMediaCommand(ctx, mp4Args); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create mp4 preview: %w", err)
+	}
+
+	webpArgs := []string{
+		"ffmpeg", "-y",
+		"-ss", start, "-t", length, "-i", videoPath,
+		"-an",
+		"-vf", "fps=12," + scale,
+		"-c:v", "libwebp",
+		"-loop", "0",
+		"-quality", "60",
+		"-compression_level", "4",
+		filepath.Join(dir, "preview.webp"),
+	}
+	if _, err := runMediaCommand(ctx, webpArgs); err != nil {
+		log.Printf("Skipping animated WebP preview for %s: %v", videoPath, err)
+		os.Remove(filepath.Join(dir, "preview.webp"))
+	}
+
+	clip := &previewClip{dir: dir, names: map[string]string{}}
+	for _, format := range []string{"mp4", "webp"} {
+		path := filepath.Join(dir, "preview."+format)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		name, err := versionPreview(path)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		clip.names[format] = name
+	}
+
+	return clip, nil
+}
+
+// versionPreview renames a preview file after its content hash and returns
+// the new file name
+func versionPreview(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	file.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+
+	name := previewPrefix + hex.EncodeToString(hash.Sum(nil))[:previewHashLength] + filepath.Ext(path)
+	if err := os.Rename(path, filepath.Join(filepath.Dir(path), name)); err != nil {
+		return "", fmt.Errorf("failed to rename %s: %w", path, err)
+	}
+	return name, nil
+}
+
+// uploadPreview stores a preview clip, points the video at it and removes the
+// versions it replaces. Old versions are only dropped once the new keys are
+// recorded, so the metadata API never hands out a missing clip.
+func (vp *VideoProcessor) uploadPreview(ctx context.Context, clip *previewClip, videoKey string) error {
+	if err := vp.uploadThumbnailDir(ctx, clip.dir, videoKey); err != nil {
+		return err
+	}
+
+	previous, err := vp.db.GetPreviews(videoKey)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]string, len(clip.names))
+	current := make(map[string]bool, len(clip.names))
+	for format, name := range clip.names {
+		key := StreamPrefix(videoKey) + "/" + name
+		keys[format] = key
+		current[key] = true
+	}
+	if err := vp.db.SavePreviews(videoKey, keys); err != nil {
+		return err
+	}
+
+	for _, key := range previous {
+		if current[key] {
+			continue
+		}
+		if err := vp.storage.DeleteObject(ctx, vp.storage.thumbnailBucket, key); err != nil {
+			log.Printf("Failed to remove old preview %s: %v", key, err)
+		}
+	}
+	return nil
+}
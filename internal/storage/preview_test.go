@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVersionedAsset(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"preview-0123456789abcdef.mp4", true},
+		{"preview-0123456789abcdef.webp", true},
+		{"preview.mp4", false},
+		{"preview-.mp4", false},
+		{"preview-0123456789abcde.mp4", false},
+		{"preview-0123456789abcdefg.mp4", false},
+		{"preview-0123456789abcdeg.mp4", false},
+		{"storyboard.vtt", false},
+		{"candidate-1.jpg", false},
+	}
+
+	for _, tt := range tests {
+		if got := VersionedAsset(tt.name); got != tt.want {
+			t.Errorf("VersionedAsset(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVersionPreview(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "preview.mp4")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		name, err := versionPreview(path)
+		if err != nil {
+			t.Fatalf("versionPreview: %v", err)
+		}
+		if !VersionedAsset(name) {
+			t.Errorf("versionPreview named the file %q, which is not a versioned asset", name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("renamed file missing: %v", err)
+		}
+		return name
+	}
+
+	first := write("first clip")
+	if again := write("first clip"); again != first {
+		t.Errorf("same content named %q and %q", first, again)
+	}
+	if second := write("second clip"); second == first {
+		t.Errorf("different content both named %q", first)
+	}
+}
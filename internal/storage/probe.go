@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dayquest/cdn/internal/database"
+)
+
+// sourceInfo is what ffprobe tells us about an upload. Width and Height are
+// the display dimensions, i.e. with Rotation already applied.
+type sourceInfo struct {
+	Width      int
+	Height     int
+	Rotation   int
+	FrameRate  float64
+	VideoCodec string
+	AudioCodec string
+	Bitrate    int64
+	HasAudio   bool
+	Duration   time.Duration
+}
+
+// ShortSide returns the smaller of the two dimensions, which is what the ladder
+// rungs refer to regardless of orientation
+func (s sourceInfo) ShortSide() int {
+	if s.Width < s.Height {
+		return s.Width
+	}
+	return s.Height
+}
+
+// MediaInfo converts the probe result into its persisted form
+func (s sourceInfo) MediaInfo() database.MediaInfo {
+	return database.MediaInfo{
+		Duration:   s.Duration.Seconds(),
+		Width:      s.Width,
+		Height:     s.Height,
+		FrameRate:  s.FrameRate,
+		VideoCodec: s.VideoCodec,
+		AudioCodec: s.AudioCodec,
+		Rotation:   s.Rotation,
+		Bitrate:    s.Bitrate,
+	}
+}
+
+type probeOutput struct {
+	Streams []struct {
+		CodecType    string            `json:"codec_type"`
+		CodecName    string            `json:"codec_name"`
+		Width        int               `json:"width"`
+		Height       int               `json:"height"`
+		AvgFrameRate string            `json:"avg_frame_rate"`
+		RFrameRate   string            `json:"r_frame_rate"`
+		BitRate      string            `json:"bit_rate"`
+		Tags         map[string]string `json:"tags"`
+		SideDataList []struct {
+			Rotation *float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+		BitRate  string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+func probeSource(ctx context.Context, inputPath string) (sourceInfo, error) {
+	cmdArgs := []string{
+		"ffprobe", "-v", "error",
+		"-show_streams", "-show_format",
+		"-of", "json",
+		inputPath,
+	}
+	out, err := runMediaCommand(ctx, cmdArgs)
+	if err != nil {
+		return sourceInfo{}, err
+	}
+
+	var probe probeOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return sourceInfo{}, Permanent(fmt.Errorf("failed to parse ffprobe output: %w", err))
+	}
+
+	var info sourceInfo
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			// Cover art shows up as a video stream too; the first real one wins
+			if info.VideoCodec != "" || stream.Width == 0 || stream.Height == 0 {
+				continue
+			}
+			info.VideoCodec = stream.CodecName
+			info.Width, info.Height = stream.Width, stream.Height
+			info.FrameRate = parseFrameRate(stream.AvgFrameRate)
+			if info.FrameRate == 0 {
+				info.FrameRate = parseFrameRate(stream.RFrameRate)
+			}
+
+			if rotate, err := strconv.Atoi(stream.Tags["rotate"]); err == nil {
+				info.Rotation = rotate
+			}
+			for _, sideData := range stream.SideDataList {
+				if sideData.Rotation != nil {
+					// The display matrix rotates counter-clockwise; store clockwise
+					// degrees like the legacy rotate tag
+					info.Rotation = -int(*sideData.Rotation)
+				}
+			}
+			info.Rotation = ((info.Rotation % 360) + 360) % 360
+			if info.Rotation == 90 || info.Rotation == 270 {
+				info.Width, info.Height = info.Height, info.Width
+			}
+		case "audio":
+			if !info.HasAudio {
+				info.HasAudio = true
+				info.AudioCodec = stream.CodecName
+			}
+		}
+	}
+
+	if seconds, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+		info.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	if bitrate, err := strconv.ParseInt(probe.Format.BitRate, 10, 64); err == nil {
+		info.Bitrate = bitrate
+	}
+	if info.Width == 0 || info.Height == 0 {
+		return sourceInfo{}, Permanent(fmt.Errorf("no video stream found"))
+	}
+
+	return info, nil
+}
+
+// parseFrameRate parses ffprobe's rational frame rates such as "30000/1001"
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(rate, 64)
+		return f
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
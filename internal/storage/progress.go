@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"io"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/dayquest/cdn/internal/database"
+)
+
+// Processing stages reported while a job runs, in pipeline order
+const (
+	StageDownload  = "download"
+	StageTranscode = "transcode"
+	StageThumbnail = "thumbnail"
+	StageUpload    = "upload"
+)
+
+// progressWriteInterval throttles how often progress within one stage is
+// written to the database
+const progressWriteInterval = 2 * time.Second
+
+// progressReporter persists the overall progress of one job. Each stage owns a
+// slice of the 0-100 range so the reported percentage only ever moves forward.
+type progressReporter struct {
+	db      *database.DBHandler
+	key     string
+	started time.Time
+
+	mu        sync.Mutex
+	lastStage string
+	lastWrite time.Time
+}
+
+func newProgressReporter(db *database.DBHandler, key string) *progressReporter {
+	return &progressReporter{db: db, key: key, started: time.Now()}
+}
+
+// span returns a callback that maps the fraction of a stage that is done onto
+// the from-to percentage range of the overall progress
+func (p *progressReporter) span(stage string, from, to float64) func(fraction float64) {
+	return func(fraction float64) {
+		if fraction < 0 {
+			fraction = 0
+		}
+		if fraction > 1 {
+			fraction = 1
+		}
+		p.report(stage, from+(to-from)*fraction)
+	}
+}
+
+func (p *progressReporter) report(stage string, percent float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if stage == p.lastStage && now.Sub(p.lastWrite) < progressWriteInterval {
+		return
+	}
+	p.lastStage, p.lastWrite = stage, now
+
+	progress := database.JobProgress{Stage: stage, Percent: percent}
+	if percent > 0 {
+		elapsed := now.Sub(p.started)
+		progress.ETA = time.Duration(float64(elapsed) * (100 - percent) / percent)
+	}
+
+	if err := p.db.UpdateJobProgress(p.key, progress); err != nil {
+		log.Printf("Failed to record progress for %s: %v", p.key, err)
+	}
+
+	err := p.db.PublishVideoEvent(database.VideoEvent{
+		Key:        p.key,
+		Status:     database.StatusProcessing.String(),
+		Stage:      stage,
+		Percent:    math.Round(percent*10) / 10,
+		ETASeconds: int(progress.ETA / time.Second),
+	})
+	if err != nil {
+		log.Printf("Failed to publish progress for %s: %v", p.key, err)
+	}
+}
+
+// progressReader reports how much of a known-size stream has been read
+type progressReader struct {
+	io.Reader
+	total  int64
+	read   int64
+	report func(fraction float64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+	if r.total > 0 {
+		r.report(float64(r.read) / float64(r.total))
+	}
+	return n, err
+}
@@ -3,11 +3,14 @@ package storage
 import (
 	"context"
 	"io"
+	"time"
 )
 
 type ObjectInfo struct {
-	Size        int64
-	ContentType string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
 }
 
 type Storage interface {
@@ -20,4 +23,14 @@ type Storage interface {
 	StatThumbnail(ctx context.Context, objectName string) (ObjectInfo, error)
 	StatVideo(ctx context.Context, objectName string) (ObjectInfo, error)
 	StatProfileImage(ctx context.Context, imagePath string) (ObjectInfo, error)
+	UploadThumbnail(ctx context.Context, objectName string, reader io.Reader, contentType string) error
+	UploadProfileImage(ctx context.Context, objectName string, reader io.Reader, contentType string) error
+	PurgeThumbnailVariants(ctx context.Context, objectName string) error
+	PurgeProfileImageVariants(ctx context.Context, imagePath string) error
+	DeleteProfileImage(ctx context.Context, profileImageID string) error
+	GetBadgeImage(ctx context.Context, objectName string) (io.ReadCloser, error)
+	StatBadgeImage(ctx context.Context, objectName string) (ObjectInfo, error)
+	UploadBadgeImage(ctx context.Context, objectName string, reader io.Reader, contentType string) error
+	DeleteBadgeImage(ctx context.Context, objectName string) error
+	PurgeBadgeVariants(ctx context.Context, objectName string) error
 }
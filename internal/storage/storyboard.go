@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// StoryboardVTT is the name of the WebVTT file mapping time ranges to sprite
+// regions, stored under a video's prefix in the thumbnail bucket
+const StoryboardVTT = "storyboard.vtt"
+
+// Storyboard sprite layout
+const (
+	storyboardTileWidth = 160
+	storyboardColumns   = 10
+	storyboardRows      = 10
+)
+
+// storyboard describes the sprite sheets generated for one video
+type storyboard struct {
+	dir        string
+	interval   time.Duration
+	duration   time.Duration
+	tileWidth  int
+	tileHeight int
+	frames     int
+}
+
+// createStoryboard grabs a frame every interval, tiles the frames into sprite
+// sheets and writes the sheets plus a WebVTT index into a new temp dir
+func (vp *VideoProcessor) createStoryboard(ctx context.Context, videoPath string, source sourceInfo) (*storyboard, error) {
+	dir, err := os.MkdirTemp("", "storyboard-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storyboard dir: %w", err)
+	}
+
+	sb := &storyboard{
+		dir:       dir,
+		interval:  vp.spriteInterval,
+		duration:  source.Duration,
+		tileWidth: storyboardTileWidth,
+		// Even height keeping the display aspect ratio, as the encoder needs it
+		tileHeight: int(math.Round(float64(storyboardTileWidth)*float64(source.Height)/float64(source.Width)/2)) * 2,
+	}
+	if sb.tileHeight < 2 {
+		sb.tileHeight = 2
+	}
+	sb.frames = int(math.Ceil(source.Duration.Seconds() / sb.interval.Seconds()))
+	if sb.frames < 1 {
+		sb.frames = 1
+	}
+
+	filter := fmt.Sprintf("fps=1/%g,scale=%d:%d,tile=%dx%d",
+		sb.interval.Seconds(), sb.tileWidth, sb.tileHeight, storyboardColumns, storyboardRows)
+	cmdArgs := []string{
+		"ffmpeg", "-y", "-i", videoPath,
+		"-vf", filter,
+		"-q:v", "5",
+		filepath.Join(dir, "sprite-%03d.jpg"),
+	}
+	if _, err := runMediaCommand(ctx, cmdArgs); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create storyboard sprites: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, StoryboardVTT), []byte(sb.vtt()), 0o644); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to write storyboard index: %w", err)
+	}
+
+	return sb, nil
+}
+
+// vtt renders the WebVTT index. Sprite URLs are relative, so they resolve
+// next to the VTT file wherever it is served from.
+func (sb *storyboard) vtt() string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+
+	perSheet := storyboardColumns * storyboardRows
+	for i := 0; i < sb.frames; i++ {
+		start := time.Duration(i) * sb.interval
+		end := start + sb.interval
+		if end > sb.duration && sb.duration > start {
+			end = sb.duration
+		}
+
+		tile := i % perSheet
+		x := (tile % storyboardColumns) * sb.tileWidth
+		y := (tile / storyboardColumns) * sb.tileHeight
+
+		fmt.Fprintf(&b, "\n%s --> %s\nsprite-%03d.jpg#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), i/perSheet+1, x, y, sb.tileWidth, sb.tileHeight)
+	}
+
+	return b.String()
+}
+
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// uploadThumbnailDir stores every file in dir in the thumbnail bucket under
+// the video's prefix
+func (vp *VideoProcessor) uploadThumbnailDir(ctx context.Context, dir, videoKey string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	prefix := StreamPrefix(videoKey)
+	for _, entry := range entries {
+		objectName := prefix + "/" + entry.Name()
+		if err := vp.uploadThumbnailFile(ctx, filepath.Join(dir, entry.Name()), objectName, ThumbnailAssetContentType(entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ThumbnailAssetContentType returns the content type for a file stored under
+// a video's prefix in the thumbnail bucket, or "" if the type is not served
+func ThumbnailAssetContentType(name string) string {
+	switch filepath.Ext(name) {
+	case ".jpg":
+		return "image/jpeg"
+	case ".vtt":
+		return "text/vtt"
+	case ".webp":
+		return "image/webp"
+	case ".mp4":
+		return "video/mp4"
+	default:
+		return ""
+	}
+}
+
+func (vp *VideoProcessor) uploadThumbnailFile(ctx context.Context, path, objectName, contentType string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	_, err = vp.storage.PutObject(
+		ctx,
+		vp.storage.thumbnailBucket,
+		objectName,
+		file,
+		-1,
+		minio.PutObjectOptions{ContentType: contentType},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s to thumbnail bucket: %w", objectName, err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// sniffLength is how much of an upload is fetched to identify its container;
+// enough to see three MPEG-TS sync bytes
+const sniffLength = 512
+
+// mpegTSPacketSize is the distance between MPEG-TS sync bytes
+const mpegTSPacketSize = 188
+
+// Bounds that no real upload falls outside of, independent of configuration
+const (
+	minVideoDimension = 16
+	minVideoDuration  = 100 * time.Millisecond
+)
+
+// RejectionError explains why an upload was refused before transcoding. It is
+// always permanent; the reason is what gets recorded for the video.
+type RejectionError struct {
+	Reason string
+}
+
+func (e *RejectionError) Error() string {
+	return "upload rejected: " + e.Reason
+}
+
+func reject(format string, args ...interface{}) error {
+	return Permanent(&RejectionError{Reason: fmt.Sprintf(format, args...)})
+}
+
+// UploadLimits bounds what the pre-flight stage accepts
+type UploadLimits struct {
+	MaxBytes     int64
+	MaxDuration  time.Duration
+	MaxDimension int
+}
+
+// containerSignature identifies a container format by its leading bytes
+type containerSignature struct {
+	name   string
+	ext    string
+	offset int
+	magic  []byte
+}
+
+// containerSignatures lists the video containers ffmpeg is expected to ingest
+var containerSignatures = []containerSignature{
+	{name: "quicktime", ext: ".mov", offset: 4, magic: []byte("ftypqt")},
+	{name: "mp4", ext: ".mp4", offset: 4, magic: []byte("ftyp")},
+	{name: "mp4", ext: ".mp4", offset: 4, magic: []byte("moov")},
+	{name: "quicktime", ext: ".mov", offset: 4, magic: []byte("mdat")},
+	{name: "quicktime", ext: ".mov", offset: 4, magic: []byte("wide")},
+	{name: "matroska", ext: ".mkv", offset: 0, magic: []byte{0x1a, 0x45, 0xdf, 0xa3}},
+	{name: "avi", ext: ".avi", offset: 8, magic: []byte("AVI ")},
+	{name: "mpeg-ps", ext: ".mpg", offset: 0, magic: []byte{0x00, 0x00, 0x01, 0xba}},
+	{name: "flv", ext: ".flv", offset: 0, magic: []byte("FLV")},
+	{name: "asf", ext: ".wmv", offset: 0, magic: []byte{0x30, 0x26, 0xb2, 0x75, 0x8e, 0x66, 0xcf, 0x11}},
+	{name: "ogg", ext: ".ogv", offset: 0, magic: []byte("OggS")},
+}
+
+// sniffContainer returns the container name and file extension for the
+// leading bytes of an upload, or ok=false if it is not a known video format
+func sniffContainer(head []byte) (name, ext string, ok bool) {
+	for _, sig := range containerSignatures {
+		end := sig.offset + len(sig.magic)
+		if len(head) >= end && bytes.Equal(head[sig.offset:end], sig.magic) {
+			if sig.name == "avi" && !bytes.HasPrefix(head, []byte("RIFF")) {
+				continue
+			}
+			return sig.name, sig.ext, true
+		}
+	}
+
+	// MPEG-TS has no magic number, only a sync byte at every packet start
+	if len(head) > 2*mpegTSPacketSize && head[0] == 0x47 &&
+		head[mpegTSPacketSize] == 0x47 && head[2*mpegTSPacketSize] == 0x47 {
+		return "mpegts", ".ts", true
+	}
+
+	return "", "", false
+}
+
+// preflight checks an upload before it is downloaded: its size against the
+// configured limit and its leading bytes against known video containers. It
+// returns the file extension to store the download under.
+func (vp *VideoProcessor) preflight(ctx context.Context, key string, size int64) (string, error) {
+	if size <= 0 {
+		return "", reject("file is empty")
+	}
+	if vp.limits.MaxBytes > 0 && size > vp.limits.MaxBytes {
+		return "", reject("file is %d bytes, limit is %d bytes", size, vp.limits.MaxBytes)
+	}
+
+	reader, err := vp.storage.GetObject(ctx, key, 0, sniffLength-1)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file header: %w", err)
+	}
+	defer reader.Close()
+
+	head, err := io.ReadAll(io.LimitReader(reader, sniffLength))
+	if err != nil {
+		return "", fmt.Errorf("failed to read file header: %w", err)
+	}
+
+	_, ext, ok := sniffContainer(head)
+	if !ok {
+		return "", reject("file is not a recognised video container")
+	}
+
+	return ext, nil
+}
+
+// validateSource applies the configured limits to the probe result
+func (vp *VideoProcessor) validateSource(source sourceInfo) error {
+	if source.Width < minVideoDimension || source.Height < minVideoDimension {
+		return reject("resolution %dx%d is below the minimum of %dx%d",
+			source.Width, source.Height, minVideoDimension, minVideoDimension)
+	}
+	if vp.limits.MaxDimension > 0 && (source.Width > vp.limits.MaxDimension || source.Height > vp.limits.MaxDimension) {
+		return reject("resolution %dx%d exceeds the maximum dimension of %d",
+			source.Width, source.Height, vp.limits.MaxDimension)
+	}
+	if source.Duration < minVideoDuration {
+		return reject("duration %s is too short", source.Duration)
+	}
+	if vp.limits.MaxDuration > 0 && source.Duration > vp.limits.MaxDuration {
+		return reject("duration %s exceeds the limit of %s",
+			source.Duration.Round(time.Second), vp.limits.MaxDuration)
+	}
+	return nil
+}
+
+// failureReason returns the text recorded for a failed video: the bare
+// rejection reason for refused uploads, the full error chain otherwise
+func failureReason(err error) string {
+	var rejection *RejectionError
+	if errors.As(err, &rejection) {
+		return rejection.Reason
+	}
+	return err.Error()
+}
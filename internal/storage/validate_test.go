@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+// box builds the start of an ISO BMFF file: a size, a box type and a payload
+func box(kind, payload string) []byte {
+	return append([]byte{0x00, 0x00, 0x00, 0x20}, kind+payload...)
+}
+
+// mpegTS builds n packets of MPEG-TS, each starting with the sync byte
+func mpegTS(n int) []byte {
+	data := make([]byte, n*mpegTSPacketSize)
+	for i := 0; i < n; i++ {
+		data[i*mpegTSPacketSize] = 0x47
+	}
+	return data
+}
+
+func TestSniffContainer(t *testing.T) {
+	ebml := []byte{0x1a, 0x45, 0xdf, 0xa3, 0x9f, 0x42, 0x86, 0x81, 0x01}
+
+	tests := []struct {
+		name     string
+		head     []byte
+		wantName string
+		wantExt  string
+	}{
+		{name: "mp4 isom brand", head: box("ftyp", "isom\x00\x00\x02\x00"), wantName: "mp4", wantExt: ".mp4"},
+		{name: "mp4 mp42 brand", head: box("ftyp", "mp42\x00\x00\x00\x00"), wantName: "mp4", wantExt: ".mp4"},
+		{name: "mp4 iso6 brand", head: box("ftyp", "iso6"), wantName: "mp4", wantExt: ".mp4"},
+		{name: "m4v brand", head: box("ftyp", "M4V "), wantName: "mp4", wantExt: ".mp4"},
+		{name: "quicktime brand", head: box("ftyp", "qt  \x20\x05\x03\x00"), wantName: "quicktime", wantExt: ".mov"},
+		{name: "moov first", head: box("moov", ""), wantName: "mp4", wantExt: ".mp4"},
+		{name: "quicktime mdat first", head: box("mdat", ""), wantName: "quicktime", wantExt: ".mov"},
+		{name: "quicktime wide atom", head: box("wide", ""), wantName: "quicktime", wantExt: ".mov"},
+		{name: "webm", head: append(ebml, "\x42\x82\x84webm"...), wantName: "matroska", wantExt: ".mkv"},
+		{name: "matroska", head: append(ebml, "\x42\x82\x88matroska"...), wantName: "matroska", wantExt: ".mkv"},
+		{name: "avi", head: []byte("RIFF\x00\x10\x00\x00AVI LIST"), wantName: "avi", wantExt: ".avi"},
+		{name: "mpeg program stream", head: []byte{0x00, 0x00, 0x01, 0xba, 0x44}, wantName: "mpeg-ps", wantExt: ".mpg"},
+		{name: "flv", head: []byte("FLV\x01\x05"), wantName: "flv", wantExt: ".flv"},
+		{name: "asf", head: []byte{0x30, 0x26, 0xb2, 0x75, 0x8e, 0x66, 0xcf, 0x11, 0xa6, 0xd9}, wantName: "asf", wantExt: ".wmv"},
+		{name: "ogg", head: []byte("OggS\x00\x02"), wantName: "ogg", wantExt: ".ogv"},
+		{name: "mpeg transport stream", head: mpegTS(3), wantName: "mpegts", wantExt: ".ts"},
+
+		{name: "empty", head: nil},
+		{name: "truncated box header", head: []byte{0x00, 0x00, 0x00}},
+		{name: "truncated ftyp", head: []byte("\x00\x00\x00\x20fty")},
+		{name: "truncated ebml", head: ebml[:3]},
+		{name: "truncated riff", head: []byte("RIFF\x00\x10\x00\x00AVI")},
+		{name: "transport stream cut before third packet", head: mpegTS(2)},
+		{name: "wav is riff but not avi", head: []byte("RIFF\x00\x10\x00\x00WAVEfmt ")},
+		{name: "jpeg", head: []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10, 'J', 'F', 'I', 'F'}},
+		{name: "png", head: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")},
+		{name: "text", head: []byte("hello, this is not a video file")},
+		{name: "transport stream with a missing sync byte", head: func() []byte {
+			data := mpegTS(3)
+			data[mpegTSPacketSize] = 0
+			return data
+		}()},
+		{name: "zeros", head: bytes.Repeat([]byte{0}, sniffLength)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, ext, ok := sniffContainer(tt.head)
+			if ok != (tt.wantName != "") {
+				t.Fatalf("sniffContainer ok = %v, want %v (got %q)", ok, tt.wantName != "", name)
+			}
+			if name != tt.wantName || ext != tt.wantExt {
+				t.Errorf("sniffContainer = %q, %q, want %q, %q", name, ext, tt.wantName, tt.wantExt)
+			}
+		})
+	}
+}
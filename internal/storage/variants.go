@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// variantsPrefix holds derived images next to their originals in the same
+// bucket, out of the way of the legacy profile image lookup patterns
+const variantsPrefix = "variants/"
+
+// VariantKey returns the key a derived version of objectName is cached under
+func VariantKey(objectName, variant string) string {
+	return variantsPrefix + objectName + "/" + variant
+}
+
+// PurgeThumbnailVariants drops every cached variant of a thumbnail, for use
+// when the original is replaced
+func (s *MinioStorage) PurgeThumbnailVariants(ctx context.Context, objectName string) error {
+	return s.removePrefix(ctx, s.thumbnailBucket, variantsPrefix+objectName+"/")
+}
+
+// PurgeProfileImageVariants drops every cached variant of a profile image
+func (s *MinioStorage) PurgeProfileImageVariants(ctx context.Context, imagePath string) error {
+	return s.removePrefix(ctx, s.profileImageBucket, variantsPrefix+imagePath+"/")
+}
+
+func (s *MinioStorage) removePrefix(ctx context.Context, bucketName, prefix string) error {
+	objects := s.client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	})
+
+	var firstErr error
+	for removeErr := range s.client.RemoveObjects(ctx, bucketName, objects, minio.RemoveObjectsOptions{}) {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("failed to remove %s: %w", removeErr.ObjectName, removeErr.Err)
+		}
+	}
+	return firstErr
+}
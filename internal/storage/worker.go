@@ -1,112 +1,410 @@
 package storage
 
 import (
+    "errors"
     "fmt"
     "io"
+    "log"
+    "net/url"
     "os"
-    "os/exec"
     "path/filepath"
+    "strconv"
     "strings"
     "sync"
     "time"
     "context"
     "github.com/minio/minio-go/v7"
+    "github.com/dayquest/cdn/internal/backoff"
+    "github.com/dayquest/cdn/internal/config"
     "github.com/dayquest/cdn/internal/database"
+    "github.com/dayquest/cdn/internal/webhooks"
 )
 
+// errAlreadyHandled means the video's status was moved on by another instance,
+// so there is nothing left for this job to do
+var errAlreadyHandled = errors.New("video already handled")
+
+// leaderLockName is the advisory lock that elects the instance running the
+// reconciliation scans
+const leaderLockName = "video-reconciler"
+
+// jobPollInterval bounds how long an idle worker waits before checking the job
+// queue again, which is also how quickly expired leases are picked up
+const jobPollInterval = 10 * time.Second
+
 type VideoProcessor struct {
-    storage        *MinioStorage
-    db             *database.DBHandler
-    processedFiles sync.Map
-    workerCount    int
+    storage           *MinioStorage
+    db                *database.DBHandler
+    workerCount       int
+    packaging         string
+    reconcileInterval time.Duration
+    workerID          string
+    leaseDuration     time.Duration
+    maxAttempts       int
+    retryBaseDelay    time.Duration
+    retryMaxDelay     time.Duration
+    limits            UploadLimits
+    spriteInterval    time.Duration
+    wake              chan struct{}
+    leaderLock        *database.AdvisoryLock
+    webhooks          *webhooks.Dispatcher
 }
 
-func NewVideoProcessor(storage *MinioStorage, db *database.DBHandler, workerCount int) *VideoProcessor {
+func NewVideoProcessor(storage *MinioStorage, db *database.DBHandler, cfg *config.Config, dispatcher *webhooks.Dispatcher, workerCount int) *VideoProcessor {
     return &VideoProcessor{
-        storage:     storage,
-        db:          db,
-        workerCount: workerCount,
+        storage:           storage,
+        db:                db,
+        workerCount:       workerCount,
+        packaging:         cfg.PackagingMode,
+        reconcileInterval: cfg.ReconcileInterval,
+        workerID:          cfg.WorkerID,
+        leaseDuration:     cfg.JobLeaseDuration,
+        maxAttempts:       cfg.MaxAttempts,
+        retryBaseDelay:    cfg.RetryBaseDelay,
+        retryMaxDelay:     cfg.RetryMaxDelay,
+        limits:            UploadLimits{
+            MaxBytes:     cfg.MaxUploadBytes,
+            MaxDuration:  cfg.MaxVideoDuration,
+            MaxDimension: cfg.MaxVideoDimension,
+        },
+        spriteInterval:    cfg.StoryboardInterval,
+        wake:              make(chan struct{}, 1),
+        webhooks:          dispatcher,
     }
 }
 
+// Start turns bucket notifications on the raw videos bucket into jobs in the
+// processing queue and runs the workers that drain it. A full listing runs once
+// on startup and then every reconcileInterval to pick up anything uploaded
+// while the notification stream was down.
 func (vp *VideoProcessor) Start(ctx context.Context) {
 
-    workChan := make(chan minio.ObjectInfo, vp.workerCount)
     var wg sync.WaitGroup
 
     for i := 0; i < vp.workerCount; i++ {
         wg.Add(1)
-        go vp.processWorker(ctx, &wg, workChan)
+        go vp.processWorker(ctx, &wg)
     }
+    defer wg.Wait()
+
+    notifications := vp.storage.ListenRawUploads(ctx)
+
+    ticker := time.NewTicker(vp.reconcileInterval)
+    defer ticker.Stop()
+
+    defer func() {
+        if vp.leaderLock != nil {
+            vp.leaderLock.Release()
+            vp.leaderLock = nil
+        }
+    }()
+
+    vp.reconcile(ctx)
 
     for {
         select {
         case <-ctx.Done():
-            close(workChan)
-            wg.Wait()
             return
-        default:
-            objects, err := vp.storage.ListObjects(ctx, vp.storage.rawVideosBucket)
-            if err != nil {
-                time.Sleep(10 * time.Second)
+        case info, ok := <-notifications:
+            if !ok {
+                // The listener gave up on the connection; resubscribe and
+                // rescan so nothing uploaded in between is missed
+                log.Printf("Bucket notification stream for %s closed, resubscribing", vp.storage.rawVideosBucket)
+                select {
+                case <-ctx.Done():
+                    return
+                case <-time.After(5 * time.Second):
+                }
+                notifications = vp.storage.ListenRawUploads(ctx)
+                vp.reconcile(ctx)
+                continue
+            }
+            if info.Err != nil {
+                log.Printf("Bucket notification error: %v", info.Err)
                 continue
             }
 
-            for _, obj := range objects {
-                if _, exists := vp.processedFiles.Load(obj.Key); exists {
-                    continue
+            for _, record := range info.Records {
+                key, err := url.QueryUnescape(record.S3.Object.Key)
+                if err != nil {
+                    key = record.S3.Object.Key
                 }
+                vp.enqueue(key)
+            }
+        case <-ticker.C:
+            vp.reconcile(ctx)
+        }
+    }
+}
 
+// reconcile lists the raw videos bucket and makes sure every object has a job.
+// Every instance enqueues from its own notifications, but only the leader
+// scans, so scaling out does not multiply the listing load.
+func (vp *VideoProcessor) reconcile(ctx context.Context) {
+    if !vp.ensureLeadership(ctx) {
+        return
+    }
+
+    objects, err := vp.storage.ListObjects(ctx, vp.storage.rawVideosBucket)
+    if err != nil {
+        log.Printf("Failed to list %s for reconciliation: %v", vp.storage.rawVideosBucket, err)
+        return
+    }
 
+    for _, obj := range objects {
+        vp.enqueue(obj.Key)
+    }
+}
 
-                select {
-                case workChan <- obj:
-                case <-ctx.Done():
-                    close(workChan)
-                    wg.Wait()
-                    return
-                }
-            }
+// ensureLeadership reports whether this instance is the leader, trying to
+// become one if nobody is
+func (vp *VideoProcessor) ensureLeadership(ctx context.Context) bool {
+    if vp.leaderLock != nil {
+        if vp.leaderLock.Alive(ctx) {
+            return true
         }
+        log.Printf("Lost leadership for %s", leaderLockName)
+        vp.leaderLock.Release()
+        vp.leaderLock = nil
+    }
+
+    lock, err := vp.db.TryAcquireLeadership(ctx, leaderLockName)
+    if err != nil {
+        log.Printf("Failed to acquire leadership for %s: %v", leaderLockName, err)
+        return false
+    }
+    if lock == nil {
+        return false
     }
+
+    log.Printf("Worker %s is now the leader for %s", vp.workerID, leaderLockName)
+    vp.leaderLock = lock
+    return true
+}
+
+// enqueue records a job for key and wakes an idle worker
+func (vp *VideoProcessor) enqueue(key string) {
+    if err := vp.db.EnqueueJob(key); err != nil {
+        log.Printf("Failed to enqueue %s: %v", key, err)
+        return
+    }
+    vp.signal()
 }
 
-func (vp *VideoProcessor) processWorker(ctx context.Context, wg *sync.WaitGroup, workChan <-chan minio.ObjectInfo) {
+func (vp *VideoProcessor) signal() {
+    select {
+    case vp.wake <- struct{}{}:
+    default:
+    }
+}
+
+func (vp *VideoProcessor) processWorker(ctx context.Context, wg *sync.WaitGroup) {
     defer wg.Done()
 
+    poll := time.NewTicker(jobPollInterval)
+    defer poll.Stop()
+
     for {
+        if ctx.Err() != nil {
+            return
+        }
+
+        job, err := vp.db.ClaimJob(vp.workerID, vp.leaseDuration)
+        if err != nil {
+            log.Printf("Failed to claim job: %v", err)
+        }
+        if job != nil {
+            // There may be more work queued; pass the wake-up on to another worker
+            vp.signal()
+            vp.runJob(ctx, job)
+            continue
+        }
+
         select {
         case <-ctx.Done():
             return
-        case obj, ok := <-workChan:
-            if !ok {
+        case <-vp.wake:
+        case <-poll.C:
+        }
+    }
+}
+
+// runJob processes a claimed job while keeping its lease alive and records the
+// outcome. A job interrupted by shutdown is left running so its lease expires
+// and another worker reclaims it.
+func (vp *VideoProcessor) runJob(ctx context.Context, job *database.Job) {
+    // Reclaimed leases count as attempts too, so a video that keeps taking
+    // the whole process down eventually gets dead-lettered
+    if job.Attempts > vp.maxAttempts {
+        cause := fmt.Errorf("gave up after %d attempts, last error: %s", job.Attempts-1, job.LastError)
+        if err := vp.handleFailure(ctx, job, cause); err != nil {
+            log.Printf("Failed to record outcome of job %d: %v", job.ID, err)
+        }
+        return
+    }
+
+    // The lease alone cannot rule out two instances working on the same video:
+    // a worker that stalls past its lease keeps going while the job is
+    // reclaimed. The advisory lock lives as long as the worker's session.
+    lock, err := vp.db.TryLockVideo(ctx, job.ObjectKey)
+    if err != nil || lock == nil {
+        if err != nil {
+            log.Printf("Failed to lock video %s: %v", job.ObjectKey, err)
+        } else {
+            log.Printf("Video %s is being processed by another instance, requeueing", job.ObjectKey)
+        }
+        if err := vp.db.ReleaseJob(job.ID, vp.workerID, vp.leaseDuration); err != nil {
+            log.Printf("Failed to release job %d: %v", job.ID, err)
+        }
+        return
+    }
+    defer func() {
+        if err := lock.Release(); err != nil {
+            log.Printf("Failed to unlock video %s: %v", job.ObjectKey, err)
+        }
+    }()
+
+    jobCtx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    go vp.keepLease(jobCtx, cancel, job)
+
+    err = vp.handleObject(jobCtx, minio.ObjectInfo{Key: job.ObjectKey})
+    if ctx.Err() != nil || jobCtx.Err() != nil {
+        return
+    }
+
+    if errors.Is(err, errAlreadyHandled) {
+        log.Printf("Video %s was already handled by another instance", job.ObjectKey)
+        err = vp.settleRawObject(jobCtx, job)
+    }
+    if err != nil {
+        err = vp.handleFailure(ctx, job, err)
+    } else {
+        err = vp.db.CompleteJob(job.ID, vp.workerID)
+    }
+    if err != nil {
+        log.Printf("Failed to record outcome of job %d: %v", job.ID, err)
+    }
+}
+
+// settleRawObject clears a raw upload left behind by a video that already
+// reached a final status, so reconciliation does not keep finding it. The
+// upload of a completed video is deleted; that of a failed one goes to the
+// failed bucket like any other dead-lettered upload.
+func (vp *VideoProcessor) settleRawObject(ctx context.Context, job *database.Job) error {
+    key := job.ObjectKey
+    status, err := vp.db.GetVideoStatus(key)
+    if err != nil {
+        return err
+    }
+
+    switch status {
+    case database.StatusCompleted:
+        err = vp.storage.DeleteObject(ctx, vp.storage.rawVideosBucket, key)
+    case database.StatusFailed:
+        err = vp.moveToFailedBucket(ctx, minio.ObjectInfo{Key: key}, job.Attempts, "video had already failed")
+    default:
+        return nil
+    }
+    if err != nil && !isMissingObject(err) {
+        return fmt.Errorf("failed to clear raw upload of %s video: %w", status, err)
+    }
+    return nil
+}
+
+// handleFailure schedules a retry for transient errors while attempts remain.
+// Permanent errors and exhausted jobs are dead-lettered: the video is marked
+// failed and the raw upload moved to the failed bucket.
+func (vp *VideoProcessor) handleFailure(ctx context.Context, job *database.Job, cause error) error {
+    if !IsPermanent(cause) && job.Attempts < vp.maxAttempts {
+        delay := backoff.Delay(job.Attempts, vp.retryBaseDelay, vp.retryMaxDelay)
+        log.Printf("Processing %s failed (attempt %d/%d), retrying in %s: %v",
+            job.ObjectKey, job.Attempts, vp.maxAttempts, delay.Round(time.Second), cause)
+
+        reset, err := vp.db.TransitionVideoStatus(job.ObjectKey, []database.VideoStatus{database.StatusProcessing}, database.StatusPending)
+        if err != nil {
+            log.Printf("Failed to reset status of %s to pending: %v", job.ObjectKey, err)
+        }
+        if reset {
+            vp.publish(database.VideoEvent{
+                Key:    job.ObjectKey,
+                Status: database.StatusPending.String(),
+                Reason: cause.Error(),
+            })
+        }
+        return vp.db.RetryJob(job.ID, vp.workerID, cause.Error(), delay)
+    }
+
+    log.Printf("Processing %s failed permanently after %d attempt(s): %v", job.ObjectKey, job.Attempts, cause)
+    vp.failVideo(ctx, minio.ObjectInfo{Key: job.ObjectKey}, job.Attempts, cause)
+    return vp.db.FailJob(job.ID, vp.workerID, failureReason(cause))
+}
+
+// keepLease renews the job lease until ctx is done and cancels the job when
+// the lease has been lost to another worker
+func (vp *VideoProcessor) keepLease(ctx context.Context, cancel context.CancelFunc, job *database.Job) {
+    ticker := time.NewTicker(vp.leaseDuration / 3)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            err := vp.db.RenewLease(job.ID, vp.workerID, vp.leaseDuration)
+            if errors.Is(err, database.ErrLeaseLost) {
+                log.Printf("Lost lease on job %d for %s, abandoning it", job.ID, job.ObjectKey)
+                cancel()
                 return
             }
+            if err != nil {
+                log.Printf("Failed to renew lease on job %d: %v", job.ID, err)
+            }
+        }
+    }
+}
 
-           status, err := vp.db.GetVideoStatus(obj.Key)
-           if err != nil {
-               continue
-           }
+func (vp *VideoProcessor) handleObject(ctx context.Context, obj minio.ObjectInfo) error {
+    status, err := vp.db.GetVideoStatus(obj.Key)
+    if err != nil {
+        return err
+    }
 
-           if status == database.StatusUnknown {
-               continue
-           }
+    if status == database.StatusUnknown {
+        return fmt.Errorf("video %s has unknown status", obj.Key)
+    }
 
-           if err := vp.processVideo(ctx, obj); err != nil {
-               continue
-           }
+    info, err := vp.storage.StatObject(ctx, obj.Key)
+    if err != nil {
+        if isMissingObject(err) {
+            return Permanent(fmt.Errorf("raw object unavailable: %w", err))
         }
+        return fmt.Errorf("raw object unavailable: %w", err)
     }
+    obj.Size = info.Size
+
+    return vp.processVideo(ctx, obj)
 }
 
 func (vp *VideoProcessor) processVideo(ctx context.Context, obj minio.ObjectInfo) error {
 
-    err := vp.db.UpdateVideoStatus(obj.Key, database.StatusProcessing)
+    claimed, err := vp.db.TransitionVideoStatus(obj.Key, []database.VideoStatus{database.StatusPending, database.StatusProcessing}, database.StatusProcessing)
     if err != nil {
         return fmt.Errorf("failed to update status to processing: %w", err)
     }
+    if !claimed {
+        return errAlreadyHandled
+    }
 
-    tmpFile, err := os.CreateTemp("", "video-*.mp4")
+    progress := newProgressReporter(vp.db, obj.Key)
+
+    ext, err := vp.preflight(ctx, obj.Key, obj.Size)
+    if err != nil {
+        return err
+    }
+
+    tmpFile, err := os.CreateTemp("", "upload-*"+ext)
      if err != nil {
         return fmt.Errorf("Failed to create temp file: %w", err)
     }
@@ -114,31 +412,87 @@ func (vp *VideoProcessor) processVideo(ctx context.Context, obj minio.ObjectInfo
     defer os.Remove(tmpPath)
     defer tmpFile.Close()
 
+    progress.report(StageDownload, 0)
+
     reader, err := vp.storage.GetObject(ctx, obj.Key, 0, -1)
     if err != nil {
         return fmt.Errorf("failed to get object: %w", err)
     }
     defer reader.Close()
 
-    _, err = io.Copy(tmpFile, reader)
+    _, err = io.Copy(tmpFile, &progressReader{Reader: reader, total: obj.Size, report: progress.span(StageDownload, 0, 5)})
     if err != nil {
         return fmt.Errorf("failed to write to temp file: %w", err)
     }
 
-    compressedFile, err := vp.compressAndConvertVideo(tmpPath)
+    source, err := probeSource(ctx, tmpPath)
     if err != nil {
-        err := vp.db.UpdateVideoStatus(obj.Key, database.StatusFailed)
-        moveErr := vp.moveToFailedBucket(ctx, obj)
-        if moveErr != nil {
-            fmt.Printf("Failed to move object %s to failed bucket: %v\n", obj.Key, moveErr)
+        if IsPermanent(err) {
+            return reject("file could not be read as video: %v", err)
         }
-        if err != nil {
-            return fmt.Errorf("failed to update status to failed: %w", err)
-        }
-        return fmt.Errorf("failed to compress and convert video: %w", err)
+        return fmt.Errorf("failed to probe video: %w", err)
+    }
+
+    if err := vp.validateSource(source); err != nil {
+        return err
+    }
+
+    if err := vp.db.SaveMediaInfo(obj.Key, source.MediaInfo()); err != nil {
+        return fmt.Errorf("failed to save media info: %w", err)
+    }
+
+    progress.report(StageTranscode, 5)
+
+    streamDir, err := os.MkdirTemp("", "stream-*")
+    if err != nil {
+        return fmt.Errorf("failed to create stream output dir: %w", err)
+    }
+    defer os.RemoveAll(streamDir)
+
+    if err := vp.packageStreams(ctx, tmpPath, streamDir, source, progress.span(StageTranscode, 5, 78)); err != nil {
+        return fmt.Errorf("failed to package streams: %w", err)
+    }
+
+    // The progressive MP4 is the top rung of the ladder, copied rather than
+    // encoded a second time
+    compressedFile, err := vp.remuxProgressive(ctx, streamDir, source)
+    if err != nil {
+        return fmt.Errorf("failed to build progressive video: %w", err)
     }
     defer os.Remove(compressedFile)
 
+    progress.report(StageThumbnail, 80)
+
+    thumbnailPath, err := vp.createThumbnail(ctx, tmpPath)
+    if err != nil {
+        return fmt.Errorf("failed to create thumbnail: %w", err)
+    }
+    defer os.Remove(thumbnailPath)
+
+    // Scrubbing previews are a nice-to-have; a video is not failed over them
+    sb, err := vp.createStoryboard(ctx, tmpPath, source)
+    if err != nil {
+        log.Printf("Skipping storyboard for %s: %v", obj.Key, err)
+    } else {
+        defer os.RemoveAll(sb.dir)
+    }
+
+    candidatesDir, err := vp.createThumbnailCandidates(ctx, tmpPath, thumbnailPath, source.Duration)
+    if err != nil {
+        log.Printf("Skipping thumbnail candidates for %s: %v", obj.Key, err)
+    } else {
+        defer os.RemoveAll(candidatesDir)
+    }
+
+    preview, err := vp.createPreview(ctx, tmpPath, source)
+    if err != nil {
+        log.Printf("Skipping preview clip for %s: %v", obj.Key, err)
+    } else {
+        defer os.RemoveAll(preview.dir)
+    }
+
+    progress.report(StageUpload, 85)
+
     compressedFileReader, err := os.Open(compressedFile)
     if err != nil {
         return fmt.Errorf("failed to open compressed file: %w", err)
@@ -157,14 +511,8 @@ func (vp *VideoProcessor) processVideo(ctx context.Context, obj minio.ObjectInfo
         return fmt.Errorf("failed to upload video: %w", err)
     }
 
-    err = vp.storage.DeleteObject(ctx, vp.storage.rawVideosBucket, obj.Key)
-    if err != nil {
-        return fmt.Errorf("failed to delete original video: %w", err)
-    }
-
-    thumbnailPath, err := vp.createThumbnail(tmpPath)
-    if err != nil {
-        return fmt.Errorf("failed to create thumbnail: %w", err)
+    if err := vp.uploadStreamDir(ctx, streamDir, obj.Key, progress.span(StageUpload, 88, 99)); err != nil {
+        return fmt.Errorf("failed to upload stream output: %w", err)
     }
 
     err = vp.uploadThumbnail(ctx, thumbnailPath, obj.Key)
@@ -172,40 +520,101 @@ func (vp *VideoProcessor) processVideo(ctx context.Context, obj minio.ObjectInfo
         return fmt.Errorf("failed to upload thumbnail: %w", err)
     }
 
-    err = vp.db.UpdateVideoStatus(obj.Key, database.StatusCompleted)
+    if sb != nil {
+        if err := vp.uploadThumbnailDir(ctx, sb.dir, obj.Key); err != nil {
+            return fmt.Errorf("failed to upload storyboard: %w", err)
+        }
+    }
+
+    if candidatesDir != "" {
+        if err := vp.uploadThumbnailDir(ctx, candidatesDir, obj.Key); err != nil {
+            return fmt.Errorf("failed to upload thumbnail candidates: %w", err)
+        }
+    }
+
+    if preview != nil {
+        if err := vp.uploadPreview(ctx, preview, obj.Key); err != nil {
+            return fmt.Errorf("failed to upload preview clip: %w", err)
+        }
+    }
+
+    completed, err := vp.db.TransitionVideoStatus(obj.Key, []database.VideoStatus{database.StatusProcessing}, database.StatusCompleted)
     if err != nil {
         return fmt.Errorf("failed to update status to completed: %w", err)
     }
+    if !completed {
+        return errAlreadyHandled
+    }
+
+    vp.publish(database.VideoEvent{
+        Key:    obj.Key,
+        Status: database.StatusCompleted.String(),
+        CDNURL: fmt.Sprintf("/video/%s", obj.Key),
+    })
+
+    var renditions []string
+    for _, r := range renditionsFor(source.ShortSide()) {
+        renditions = append(renditions, r.Name)
+    }
+    vp.webhooks.Notify(webhooks.Payload{
+        Event:        webhooks.EventVideoCompleted,
+        Key:          obj.Key,
+        Status:       database.StatusCompleted.String(),
+        Renditions:   renditions,
+        ThumbnailURL: fmt.Sprintf("/thumbnail/%s.jpg", StreamPrefix(obj.Key)),
+        Duration:     source.Duration.Seconds(),
+    })
+
+    // The raw upload is only removed once the video is recorded as completed,
+    // so a retry after a partial failure still has its source. Should the
+    // delete fail, the retry finds the video completed and settles the upload.
+    err = vp.storage.DeleteObject(ctx, vp.storage.rawVideosBucket, obj.Key)
+    if err != nil {
+        return fmt.Errorf("failed to delete original video: %w", err)
+    }
 
     return nil
 }
 
-func (vp *VideoProcessor) compressAndConvertVideo(inputPath string) (string, error) {
-    outputPath := fmt.Sprintf("%s-compressed.mp4", inputPath)
+// publish broadcasts a status change to event stream subscribers on every
+// instance. Delivery is best effort; clients can always fall back to polling.
+func (vp *VideoProcessor) publish(event database.VideoEvent) {
+    if err := vp.db.PublishVideoEvent(event); err != nil {
+        log.Printf("Failed to publish %s event for %s: %v", event.Status, event.Key, err)
+    }
+}
 
-    cmdArgs := []string{
-        "ffmpeg", "-y", "-i", inputPath,
-        "-c:v", "libx264",
-        "-preset", "fast",
-        "-crf", "23",
-        "-c:a", "aac",
-        "-b:a", "96k",
-        "-ar", "44100",
-        "-f", "mp4",
-        "-movflags", "+faststart",
-        outputPath,
-    }
-    cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-
-    err := cmd.Run()
+// failVideo marks the video as failed and moves the raw upload to the failed
+// bucket, tagged with the reason, so it is not picked up again. Subscribers
+// and webhooks only hear about it when this call is what failed the video.
+func (vp *VideoProcessor) failVideo(ctx context.Context, obj minio.ObjectInfo, attempts int, cause error) {
+    failed, err := vp.db.TransitionVideoStatus(obj.Key, []database.VideoStatus{database.StatusPending, database.StatusProcessing}, database.StatusFailed)
     if err != nil {
-        return "", fmt.Errorf("ffmpeg command fail: %w", err)
+        log.Printf("Failed to update status of %s to failed: %v", obj.Key, err)
+    }
+    reason := failureReason(cause)
+    if failed {
+        vp.publish(database.VideoEvent{
+            Key:    obj.Key,
+            Status: database.StatusFailed.String(),
+            Reason: reason,
+        })
+        vp.webhooks.Notify(webhooks.Payload{
+            Event:  webhooks.EventVideoFailed,
+            Key:    obj.Key,
+            Status: database.StatusFailed.String(),
+            Error:  reason,
+        })
+    }
+    if isMissingObject(cause) {
+        return
+    }
+    if err := vp.moveToFailedBucket(ctx, obj, attempts, reason); err != nil {
+        log.Printf("Failed to move object %s to failed bucket: %v", obj.Key, err)
     }
-
-    return outputPath, nil
 }
 
-func (vp *VideoProcessor) createThumbnail(videoPath string) (string, error) {
+func (vp *VideoProcessor) createThumbnail(ctx context.Context, videoPath string) (string, error) {
     videoBasePath := strings.TrimSuffix(videoPath, ".mp4")
 
     thumbnailPath := fmt.Sprintf("%s.jpg", videoBasePath)
@@ -217,9 +626,7 @@ func (vp *VideoProcessor) createThumbnail(videoPath string) (string, error) {
         thumbnailPath,
     }
 
-    cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-    err := cmd.Run()
-    if err != nil {
+    if _, err := runMediaCommand(ctx, cmdArgs); err != nil {
         return "", fmt.Errorf("failed to extract thumbnail: %w", err)
     }
 
@@ -246,11 +653,20 @@ func (vp *VideoProcessor) uploadThumbnail(ctx context.Context, thumbnailPath, vi
         return fmt.Errorf("failed to upload thumbnail to bucket: %w", err)
     }
 
+    // A reprocessed video must not keep serving resized copies of the old frame
+    if err := vp.storage.PurgeThumbnailVariants(ctx, thumbnailKey); err != nil {
+        return fmt.Errorf("failed to purge thumbnail variants: %w", err)
+    }
+
+    if err := vp.saveThumbnailPlaceholder(thumbnailPath, thumbnailKey); err != nil {
+        log.Printf("Error saving thumbnail placeholder for %s: %v", videoKey, err)
+    }
+
     return nil
 }
 
 
-func (vp *VideoProcessor) moveToFailedBucket(ctx context.Context, obj minio.ObjectInfo) error {
+func (vp *VideoProcessor) moveToFailedBucket(ctx context.Context, obj minio.ObjectInfo, attempts int, reason string) error {
     reader, err := vp.storage.GetObject(ctx, obj.Key, 0, -1)
     if err != nil {
         return fmt.Errorf("failed to get object: %w", err)
@@ -263,7 +679,14 @@ func (vp *VideoProcessor) moveToFailedBucket(ctx context.Context, obj minio.Obje
         obj.Key,
         reader,
         -1,
-        minio.PutObjectOptions{ContentType: "application/octet-stream"},
+        minio.PutObjectOptions{
+            ContentType: "application/octet-stream",
+            UserMetadata: map[string]string{
+                "Failure-Reason":   metadataValue(reason),
+                "Failure-Attempts": strconv.Itoa(attempts),
+                "Failed-At":        time.Now().UTC().Format(time.RFC3339),
+            },
+        },
     )
     if err != nil {
         return fmt.Errorf("failed to upload file to failed bucket: %w", err)
@@ -276,3 +699,21 @@ func (vp *VideoProcessor) moveToFailedBucket(ctx context.Context, obj minio.Obje
 
     return nil
 }
+
+// metadataValue makes s safe to send as an object metadata header: printable
+// ASCII only and short enough to stay well inside the 2KB metadata limit
+func metadataValue(s string) string {
+    const maxLen = 1024
+
+    var b strings.Builder
+    for _, r := range s {
+        if b.Len() >= maxLen {
+            break
+        }
+        if r < 0x20 || r > 0x7e {
+            r = ' '
+        }
+        b.WriteRune(r)
+    }
+    return b.String()
+}
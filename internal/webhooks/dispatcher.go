@@ -0,0 +1,197 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dayquest/cdn/internal/backoff"
+	"github.com/dayquest/cdn/internal/config"
+	"github.com/dayquest/cdn/internal/database"
+)
+
+// Events sent to webhook endpoints
+const (
+	EventVideoCompleted = "video.completed"
+	EventVideoFailed    = "video.failed"
+)
+
+// Headers set on every delivery. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the shared secret.
+const (
+	HeaderEvent     = "X-DayQuest-Event"
+	HeaderDelivery  = "X-DayQuest-Delivery"
+	HeaderTimestamp = "X-DayQuest-Timestamp"
+	HeaderSignature = "X-DayQuest-Signature"
+)
+
+const (
+	pollInterval  = 5 * time.Second
+	claimBatch    = 20
+	retryBase     = 10 * time.Second
+	retryMax      = time.Hour
+	maxErrorBytes = 512
+)
+
+// Payload is the JSON body of a video webhook
+type Payload struct {
+	Event        string   `json:"event"`
+	Key          string   `json:"key"`
+	Status       string   `json:"status"`
+	Renditions   []string `json:"renditions,omitempty"`
+	ThumbnailURL string   `json:"thumbnailUrl,omitempty"`
+	Duration     float64  `json:"duration,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	Timestamp    string   `json:"timestamp"`
+}
+
+// Dispatcher persists webhook deliveries and sends them with retries. Every
+// delivery is logged in the database first, so nothing is lost on restart and
+// failed deliveries can be replayed.
+type Dispatcher struct {
+	db          *database.DBHandler
+	client      *http.Client
+	urls        []string
+	secret      string
+	maxAttempts int
+	wake        chan struct{}
+}
+
+// NewDispatcher creates a dispatcher for the configured endpoints. With no
+// endpoints configured Notify does nothing.
+func NewDispatcher(db *database.DBHandler, cfg *config.Config) *Dispatcher {
+	return &Dispatcher{
+		db:          db,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		urls:        cfg.WebhookURLs,
+		secret:      cfg.WebhookSecret,
+		maxAttempts: cfg.WebhookMaxAttempts,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Notify records a delivery of payload for every configured endpoint
+func (d *Dispatcher) Notify(payload Payload) {
+	if len(d.urls) == 0 {
+		return
+	}
+
+	payload.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode webhook payload for %s: %v", payload.Key, err)
+		return
+	}
+
+	for _, url := range d.urls {
+		if err := d.db.InsertWebhookDelivery(payload.Event, url, body); err != nil {
+			log.Printf("Failed to queue %s webhook for %s: %v", payload.Event, payload.Key, err)
+		}
+	}
+
+	d.Wake()
+}
+
+// Wake makes Run look for due deliveries right away
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		deliveries, err := d.db.ClaimWebhookDeliveries(claimBatch)
+		if err != nil {
+			log.Printf("Failed to claim webhook deliveries: %v", err)
+		}
+		for _, delivery := range deliveries {
+			d.deliver(ctx, delivery)
+		}
+		if len(deliveries) == claimBatch && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery database.WebhookDelivery) {
+	status, err := d.send(ctx, delivery)
+	if err == nil {
+		if err := d.db.MarkWebhookDelivered(delivery.ID, status); err != nil {
+			log.Printf("Failed to record webhook delivery %d: %v", delivery.ID, err)
+		}
+		return
+	}
+
+	if delivery.Attempts >= d.maxAttempts {
+		log.Printf("Giving up on webhook delivery %d to %s after %d attempts: %v", delivery.ID, delivery.URL, delivery.Attempts, err)
+		err = d.db.FailWebhookDelivery(delivery.ID, status, err.Error())
+	} else {
+		err = d.db.RetryWebhookDelivery(delivery.ID, status, err.Error(), backoff.Delay(delivery.Attempts, retryBase, retryMax))
+	}
+	if err != nil {
+		log.Printf("Failed to record webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// send posts the delivery and returns the response status. Any non-2xx
+// response counts as a failure.
+func (d *Dispatcher) send(ctx context.Context, delivery database.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("invalid webhook request: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "DayQuest-CDN-Webhooks")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, "sha256="+Sign(d.secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBytes))
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 signature of a delivery body sent at the
+// given unix timestamp
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}